@@ -0,0 +1,444 @@
+// Package config reads the promobee configuration file, which is written in a
+// subset of TOML.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/promobee"
+)
+
+// Config of promobee. Settings which are not present take their values from
+// the command line flags.
+type Config struct {
+	// ListenAddress, such as ":8080", overrides --address and --port.
+	ListenAddress string   `json:"listen_address"`
+	PollInterval  Duration `json:"poll_interval"`
+	// Unit is one of fahrenheit, celsius or auto.
+	Unit string `json:"unit"`
+	// ThermostatUnits overrides Unit for the thermostats with these identifiers.
+	ThermostatUnits map[string]string `json:"thermostat_units"`
+	// Labels added to every metric of every thermostat of every account.
+	Labels    map[string]string `json:"labels"`
+	Sensors   Sensors           `json:"sensors"`
+	Selection *Selection        `json:"selection"`
+
+	// Accounts to poll, each in an [[account]] table. If there are none, the
+	// account given by --api_key and --store is polled.
+	Accounts []*Account `json:"account"`
+}
+
+// Sensors selects the sensors which are exported. See promobee.Sensors.
+type Sensors struct {
+	// Include and Exclude are regular expressions matching the whole name of a
+	// sensor.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Locations maps the names of sensors to their location label.
+	Locations map[string]string `json:"locations"`
+	// Identity is location, which is the default, or sensor_id.
+	Identity string `json:"identity"`
+	// Capabilities overrides the unit and scaling of types of sensor
+	// capability, such as airPressure.
+	Capabilities map[string]Capability `json:"capabilities"`
+}
+
+// Capability describes how a type of sensor capability is exported. See
+// promobee.Capability.
+type Capability struct {
+	Unit  string  `json:"unit"`
+	Scale float64 `json:"scale"`
+}
+
+// Selection of the thermostats to poll, and the data to fetch for each. See
+// promobee.Selection.
+type Selection struct {
+	// Type is registered, which is the default, thermostats or managementSet.
+	Type          string   `json:"type"`
+	Thermostats   []string `json:"thermostats"`
+	ManagementSet string   `json:"management_set"`
+	// Includes are the names of promobee.Includes, such as "sensors".
+	Includes []string `json:"includes"`
+}
+
+// selection which s describes, and the key of the value of s which is invalid,
+// if any.
+func (s *Selection) selection() (*promobee.Selection, string, error) {
+	sel := &promobee.Selection{
+		Type:          egobee.SelectionType(s.Type),
+		Thermostats:   s.Thermostats,
+		ManagementSet: s.ManagementSet,
+	}
+	for _, name := range s.Includes {
+		i, err := promobee.ParseInclude(name)
+		if err != nil {
+			return nil, "includes", err
+		}
+		sel.Includes = append(sel.Includes, i)
+	}
+	if err := sel.Check(); err != nil {
+		return nil, "type", err
+	}
+	return sel, "", nil
+}
+
+// Account of the ecobee API to poll.
+type Account struct {
+	// Name of the account, which qualifies the identifiers of its thermostats.
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+	// Store is the location of the token store, as for --store.
+	Store        string   `json:"store"`
+	PollInterval Duration `json:"poll_interval"`
+	Unit         string   `json:"unit"`
+	// Labels added to every metric of every thermostat of the account, in
+	// addition to those of the Config.
+	Labels map[string]string `json:"labels"`
+	// Selection overrides that of the Config.
+	Selection *Selection `json:"selection"`
+}
+
+// Duration is a time.Duration written as a string, such as "3m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Zero durations are empty.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if d.Duration == 0 {
+		return json.Marshal("")
+	}
+	return json.Marshal(d.String())
+}
+
+// Load the configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %q: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse and validate a configuration.
+func Parse(s string) (*Config, error) {
+	c, err := decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decode a configuration without validating it.
+func decode(s string) (*Config, error) {
+	m, lines, err := parseTOML(s)
+	if err != nil {
+		return nil, err
+	}
+	if unknown := unknownKeys(reflect.TypeOf(Config{}), m, ""); len(unknown) > 0 {
+		sort.Slice(unknown, func(i, j int) bool { return lines[unknown[i]] < lines[unknown[j]] })
+		return nil, fmt.Errorf("line %d: unknown key %q", lines[unknown[0]], unknown[0])
+	}
+	// The parsed TOML is decoded through JSON, so that unknown keys and values of
+	// the wrong type are reported by encoding/json.
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	c := &Config{}
+	if err := d.Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// unknownKeys returns the paths of the keys of m, which is at path, and of the
+// tables within it, which are not fields of the struct t.
+func unknownKeys(t reflect.Type, m map[string]interface{}, path string) []string {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			fields[name] = f.Type
+		}
+	}
+	var unknown []string
+	for k, v := range m {
+		p := joinPath(path, k)
+		ft, ok := fields[k]
+		if !ok {
+			unknown = append(unknown, p)
+			continue
+		}
+		unknown = append(unknown, unknownValueKeys(ft, v, p)...)
+	}
+	return unknown
+}
+
+// unknownValueKeys returns the paths of the keys within v, which is at path,
+// which are not fields of the structs within t.
+func unknownValueKeys(t reflect.Type, v interface{}, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			return unknownKeys(t, v, path)
+		case reflect.Map:
+			for k, e := range v {
+				unknown = append(unknown, unknownValueKeys(t.Elem(), e, joinPath(path, k))...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i, e := range v {
+				unknown = append(unknown, unknownValueKeys(t.Elem(), e, indexPath(path, i))...)
+			}
+		}
+	}
+	return unknown
+}
+
+// FieldError is a problem with the value of a single key of a configuration.
+type FieldError struct {
+	// Key of the value, as reported by Diff.
+	Key     string
+	Problem string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Key, e.Problem)
+}
+
+// ValidationError lists each problem with a configuration.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	var b bytes.Buffer
+	for i, fe := range e {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(fe.Error())
+	}
+	return b.String()
+}
+
+var accountNameRx = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// accountKey is the prefix of the keys of the account at index i of accounts.
+// Accounts are keyed by name, so that reordering them changes nothing, unless
+// the name is unusable.
+func accountKey(accounts []*Account, i int) string {
+	name := accounts[i].Name
+	if !accountNameRx.MatchString(name) {
+		return fmt.Sprintf("account[%d]", i)
+	}
+	for _, a := range accounts[:i] {
+		if a.Name == name {
+			return fmt.Sprintf("account[%d]", i)
+		}
+	}
+	return "account." + name
+}
+
+// Validate the configuration, returning a ValidationError if it is invalid.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{Key: key, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	if c.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+			add("listen_address", "%v", err)
+		}
+	}
+	if c.PollInterval.Duration < 0 {
+		add("poll_interval", "must not be negative")
+	}
+	if c.Unit != "" {
+		if _, err := promobee.ParseUnit(c.Unit); err != nil {
+			add("unit", "%v", err)
+		}
+	}
+	for id, u := range c.ThermostatUnits {
+		if _, err := promobee.ParseUnit(u); err != nil {
+			add("thermostat_units."+id, "%v", err)
+		}
+	}
+	if err := promobee.CheckLabels(c.Labels); err != nil {
+		add("labels", "%v", err)
+	}
+	for _, p := range c.Sensors.Include {
+		if _, err := compile(p); err != nil {
+			add("sensors.include", "invalid regular expression %q: %v", p, err)
+		}
+	}
+	for _, p := range c.Sensors.Exclude {
+		if _, err := compile(p); err != nil {
+			add("sensors.exclude", "invalid regular expression %q: %v", p, err)
+		}
+	}
+	if c.Sensors.Identity != "" {
+		if _, err := promobee.ParseSensorIdentity(c.Sensors.Identity); err != nil {
+			add("sensors.identity", "%v", err)
+		}
+	}
+	for typ, capability := range c.Sensors.Capabilities {
+		if capability.Scale < 0 {
+			add("sensors.capabilities."+typ+".scale", "must not be negative")
+		}
+	}
+	if c.Selection != nil {
+		if _, key, err := c.Selection.selection(); err != nil {
+			add("selection."+key, "%v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, a := range c.Accounts {
+		key := accountKey(c.Accounts, i)
+		switch {
+		case a.Name == "":
+			add(key+".name", "is required")
+		case !accountNameRx.MatchString(a.Name):
+			add(key+".name", "%q may only contain letters, digits, '_' and '-'", a.Name)
+		case names[a.Name]:
+			add(key+".name", "%q is used by another account", a.Name)
+		}
+		names[a.Name] = true
+		if a.APIKey == "" {
+			add(key+".api_key", "is required")
+		}
+		if a.Store == "" {
+			add(key+".store", "is required")
+		}
+		if a.PollInterval.Duration < 0 {
+			add(key+".poll_interval", "must not be negative")
+		}
+		if a.Unit != "" {
+			if _, err := promobee.ParseUnit(a.Unit); err != nil {
+				add(key+".unit", "%v", err)
+			}
+		}
+		if err := promobee.CheckLabels(merge(c.Labels, a.Labels)); err != nil {
+			add(key+".labels", "%v", err)
+		}
+		if a.Selection != nil {
+			if _, field, err := a.Selection.selection(); err != nil {
+				add(key+".selection."+field, "%v", err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// compile a pattern matching whole sensor names.
+func compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// merge labels, with those of later maps taking precedence.
+func merge(labels ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, l := range labels {
+		for k, v := range l {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Opts for polling account, which need not be one of c.Accounts. Settings of
+// the account take precedence over those of c, which take precedence over
+// defaults. c must be valid.
+func (c *Config) Opts(account *Account, defaults promobee.Opts) *promobee.Opts {
+	o := defaults
+	o.Account = account.Name
+	o.Labels = merge(defaults.Labels, c.Labels, account.Labels)
+	for _, d := range []Duration{c.PollInterval, account.PollInterval} {
+		if d.Duration != 0 {
+			o.PollInterval = d.Duration
+		}
+	}
+	for _, u := range []string{c.Unit, account.Unit} {
+		if u != "" {
+			o.Unit, _ = promobee.ParseUnit(u)
+		}
+	}
+	if len(c.ThermostatUnits) > 0 {
+		o.ThermostatUnits = make(map[string]promobee.Unit)
+		for id, u := range defaults.ThermostatUnits {
+			o.ThermostatUnits[id] = u
+		}
+		for id, u := range c.ThermostatUnits {
+			o.ThermostatUnits[id], _ = promobee.ParseUnit(u)
+		}
+	}
+	for _, sel := range []*Selection{c.Selection, account.Selection} {
+		if sel != nil {
+			o.Selection, _, _ = sel.selection()
+		}
+	}
+	s := c.Sensors
+	if len(s.Include) > 0 || len(s.Exclude) > 0 || len(s.Locations) > 0 || s.Identity != "" || len(s.Capabilities) > 0 {
+		o.Sensors = &promobee.Sensors{Locations: s.Locations}
+		o.Sensors.Identity, _ = promobee.ParseSensorIdentity(s.Identity)
+		for typ, c := range s.Capabilities {
+			if o.Sensors.Capabilities == nil {
+				o.Sensors.Capabilities = make(map[string]promobee.Capability)
+			}
+			o.Sensors.Capabilities[typ] = promobee.Capability{Unit: c.Unit, Scale: c.Scale}
+		}
+		for _, p := range s.Include {
+			rx, _ := compile(p)
+			o.Sensors.Include = append(o.Sensors.Include, rx)
+		}
+		for _, p := range s.Exclude {
+			rx, _ := compile(p)
+			o.Sensors.Exclude = append(o.Sensors.Exclude, rx)
+		}
+	}
+	return &o
+}
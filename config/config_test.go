@@ -0,0 +1,189 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/promobee"
+)
+
+const testConfig = `
+listen_address = ":9090"
+poll_interval = "4m"
+unit = "celsius"
+labels = { region = "east" }
+
+[thermostat_units]
+"123" = "fahrenheit"
+
+[sensors]
+exclude = ["Garage.*"]
+locations = { "Living Room" = "living_room" }
+identity = "sensor_id"
+capabilities = { airPressure = { unit = "kPa", scale = 10 } }
+
+[[account]]
+name = "home"
+api_key = "key1"
+store = "/var/run/promobee/home.store"
+poll_interval = "5m"
+labels = { site = "home" }
+
+[[account]]
+name = "office"
+api_key = "key2"
+store = "encfile:///var/run/promobee/office.store"
+unit = "auto"
+
+[account.labels]
+site = "office"
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse(testConfig)
+	if err != nil {
+		t.Fatalf("Parse(...): unexpected error: %v", err)
+	}
+	want := []*Account{
+		{Name: "home", APIKey: "key1", Store: "/var/run/promobee/home.store", PollInterval: Duration{5 * time.Minute}, Labels: map[string]string{"site": "home"}},
+		{Name: "office", APIKey: "key2", Store: "encfile:///var/run/promobee/office.store", Unit: "auto", Labels: map[string]string{"site": "office"}},
+	}
+	if !reflect.DeepEqual(c.Accounts, want) {
+		t.Errorf("Parse(...): got %+v, want %+v", c.Accounts, want)
+	}
+	if c.ListenAddress != ":9090" || c.PollInterval.Duration != 4*time.Minute || c.Unit != "celsius" {
+		t.Errorf("Parse(...): got %+v", c)
+	}
+}
+
+func TestConfig_Opts(t *testing.T) {
+	c, err := Parse(testConfig)
+	if err != nil {
+		t.Fatalf("Parse(...): unexpected error: %v", err)
+	}
+	defaults := promobee.Opts{PollInterval: time.Minute, Unit: promobee.UnitFahrenheit, StalePolls: 5}
+
+	home := c.Opts(c.Accounts[0], defaults)
+	if home.Account != "home" || home.PollInterval != 5*time.Minute || home.Unit != promobee.UnitCelsius || home.StalePolls != 5 {
+		t.Errorf("Opts(home): got %+v", home)
+	}
+	if want := map[string]string{"region": "east", "site": "home"}; !reflect.DeepEqual(home.Labels, want) {
+		t.Errorf("Opts(home): got labels %v, want %v", home.Labels, want)
+	}
+	if got := home.ThermostatUnits["123"]; got != promobee.UnitFahrenheit {
+		t.Errorf("Opts(home): got unit %q for thermostat 123, want fahrenheit", got)
+	}
+	if home.Sensors == nil || !reflect.DeepEqual(home.Sensors.Exclude, []*regexp.Regexp{regexp.MustCompile("^(?:Garage.*)$")}) || home.Sensors.Locations["Living Room"] != "living_room" || home.Sensors.Identity != promobee.SensorIdentityID ||
+		home.Sensors.Capabilities["airPressure"] != (promobee.Capability{Unit: "kPa", Scale: 10}) {
+		t.Errorf("Opts(home): got sensors %+v", home.Sensors)
+	}
+
+	office := c.Opts(c.Accounts[1], defaults)
+	if office.PollInterval != 4*time.Minute || office.Unit != promobee.UnitAuto {
+		t.Errorf("Opts(office): got %+v", office)
+	}
+
+	sel, err := Parse(`
+[selection]
+includes = ["settings", "sensors"]
+
+[[account]]
+name = "ems"
+api_key = "key"
+store = "s"
+selection = { type = "managementSet", management_set = "/Toronto" }
+
+[[account]]
+name = "home"
+api_key = "key"
+store = "s"
+`)
+	if err != nil {
+		t.Fatalf("Parse(...): unexpected error: %v", err)
+	}
+	want := &promobee.Selection{Type: egobee.SelectionTypeManagementSet, ManagementSet: "/Toronto"}
+	if got := sel.Opts(sel.Accounts[0], defaults).Selection; !reflect.DeepEqual(got, want) {
+		t.Errorf("Opts(ems): got selection %+v, want %+v", got, want)
+	}
+	want = &promobee.Selection{Includes: []promobee.Include{promobee.IncludeSettings, promobee.IncludeSensors}}
+	if got := sel.Opts(sel.Accounts[1], defaults).Selection; !reflect.DeepEqual(got, want) {
+		t.Errorf("Opts(home): got selection %+v, want %+v", got, want)
+	}
+
+	// The account given by flags has no settings of its own.
+	flags := (&Config{}).Opts(&Account{}, defaults)
+	if flags.Account != "" || flags.PollInterval != time.Minute || flags.Sensors != nil {
+		t.Errorf("Opts(flags): got %+v", flags)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, tt := range []struct {
+		config  string
+		wantErr []string
+	}{
+		{config: "[[account]]\nname = \"home\"\napi_key = \"key\"\nstore = \"s\"\nunknown = 1", wantErr: []string{`line 5: unknown key "account[0].unknown"`}},
+		{config: "[sensors]\ncapabilities = { co2 = { unit = \"ppm\", sacle = 10 } }\n[selectoin]\ntype = \"registered\"", wantErr: []string{`line 2: unknown key "sensors.capabilities.co2.sacle"`}},
+		{config: "labels = { \"any key\" = \"x\" }\n[selectoin]\ntype = \"registered\"", wantErr: []string{`line 2: unknown key "selectoin"`}},
+		{config: "[[account]]\npoll_interval = 3", wantErr: []string{"duration must be a string"}},
+		{
+			config: `
+listen_address = "8080"
+unit = "kelvin"
+thermostat_units = { "123" = "rankine" }
+labels = { location = "x" }
+sensors = { include = ["("], identity = "name", capabilities = { co2 = { scale = -1 } } }
+selection = { includes = ["device"] }
+
+[[account]]
+name = "home:1"
+labels = { "bad label" = "y" }
+
+[[account]]
+name = "office"
+api_key = "key"
+store = "s"
+poll_interval = "-1m"
+selection = { type = "thermostats" }
+
+[[account]]
+name = "office"
+api_key = "key"
+store = "s"
+`,
+			wantErr: []string{
+				"listen_address: address 8080: missing port",
+				`unit: invalid unit "kelvin"`,
+				`thermostat_units.123: invalid unit "rankine"`,
+				`labels: label "location" is already used`,
+				`sensors.include: invalid regular expression "("`,
+				`sensors.identity: invalid sensor identity "name"`,
+				"sensors.capabilities.co2.scale: must not be negative",
+				`account[0].name: "home:1" may only contain`,
+				"account[0].api_key: is required",
+				"account[0].store: is required",
+				`account[0].labels: "bad label" is not a valid label name`,
+				`account.office.poll_interval: must not be negative`,
+				`selection.includes: invalid include "device"`,
+				`account.office.selection.type: thermostats selection requires thermostat identifiers`,
+				`account[2].name: "office" is used by another account`,
+			},
+		},
+	} {
+		_, err := Parse(tt.config)
+		if err == nil {
+			t.Errorf("Parse(%q): want error, got nil", tt.config)
+			continue
+		}
+		for _, want := range tt.wantErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Parse(%q): error %q does not contain %q", tt.config, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Change to the value of a single key of a configuration. Values are empty if
+// the key is not set.
+type Change struct {
+	Key, Old, New string
+}
+
+func (c *Change) String() string {
+	return fmt.Sprintf("%v: %v -> %v", c.Key, display(c.Old), display(c.New))
+}
+
+func display(v string) string {
+	if v == "" {
+		return "(unset)"
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+// Diff lists the changes from old to next, sorted by key. API keys are
+// replaced by a hash, so that changes to them may be logged.
+func Diff(old, next *Config) []*Change {
+	before, after := flatten(old), flatten(next)
+	var changes []*Change
+	for k, v := range before {
+		if after[k] != v {
+			changes = append(changes, &Change{Key: k, Old: v, New: after[k]})
+		}
+	}
+	for k, v := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, &Change{Key: k, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// flatten c into the value of each key which is set.
+func flatten(c *Config) map[string]string {
+	flat := make(map[string]string)
+	if c == nil {
+		return flat
+	}
+	// Config always marshals successfully.
+	b, _ := json.Marshal(c)
+	m := make(map[string]interface{})
+	json.Unmarshal(b, &m)
+	accounts, _ := m["account"].([]interface{})
+	delete(m, "account")
+	flattenInto(flat, "", m)
+	for i, a := range accounts {
+		flattenInto(flat, accountKey(c.Accounts, i)+".", a)
+	}
+	return flat
+}
+
+func flattenInto(flat map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			flattenInto(flat, prefix+k+".", e)
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			b, _ := json.Marshal(v)
+			flat[strings.TrimSuffix(prefix, ".")] = string(b)
+		}
+	case string:
+		key := strings.TrimSuffix(prefix, ".")
+		if v == "" {
+			return
+		}
+		if strings.HasSuffix(key, "api_key") {
+			v = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(v)))[:15]
+		}
+		flat[key] = v
+	case nil:
+	default:
+		flat[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(v)
+	}
+}
+
+// Reload the configuration file at path, which previously held current, and
+// return it with the changes since. If it is invalid, the error describes each
+// value which failed validation, and how it changed.
+func Reload(path string, current *Config) (*Config, []*Change, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err := decode(string(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid config %q: %v", path, err)
+	}
+	if err := next.Validate(); err != nil {
+		before, after := flatten(current), flatten(next)
+		var lines []string
+		for _, fe := range err.(ValidationError) {
+			old, ok := before[fe.Key]
+			v, nextOK := after[fe.Key]
+			if !ok && !nextOK {
+				lines = append(lines, "  "+fe.Error())
+				continue
+			}
+			change := &Change{Key: fe.Key, Old: old, New: v}
+			lines = append(lines, fmt.Sprintf("  %v: %v", change, fe.Problem))
+		}
+		return nil, nil, fmt.Errorf("invalid config %q:\n%v", path, strings.Join(lines, "\n"))
+	}
+	return next, Diff(current, next), nil
+}
+
+// Watch the file at path until ctx is done, calling changed whenever its size or
+// modification time changes. The file is checked every interval.
+func Watch(ctx context.Context, path string, interval time.Duration, changed func()) {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	modified, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m, s := stat()
+		if m.Equal(modified) && s == size {
+			continue
+		}
+		modified, size = m, s
+		changed()
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old, err := Parse(testConfig)
+	if err != nil {
+		t.Fatalf("Parse(...): unexpected error: %v", err)
+	}
+	next, err := Parse(strings.NewReplacer(
+		`poll_interval = "5m"`, `poll_interval = "10m"`,
+		`api_key = "key2"`, `api_key = "key3"`,
+		`exclude = ["Garage.*"]`, ``,
+	).Replace(testConfig))
+	if err != nil {
+		t.Fatalf("Parse(...): unexpected error: %v", err)
+	}
+	var got []string
+	for _, c := range Diff(old, next) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`account.home.poll_interval: "5m0s" -> "10m0s"`,
+		`account.office.api_key: "sha256:b1025376" -> "sha256:f576104e"`,
+		`sensors.exclude: "[\"Garage.*\"]" -> (unset)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diff(...):\ngot  %v\nwant %v", strings.Join(got, "\n     "), strings.Join(want, "\n     "))
+	}
+	if len(Diff(old, old)) != 0 {
+		t.Errorf("Diff(...) of identical configs: got %v, want none", Diff(old, old))
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "promobee.toml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+	current, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(...): unexpected error: %v", err)
+	}
+
+	invalid := strings.Replace(testConfig, `poll_interval = "5m"`, `poll_interval = "-5m"`, 1)
+	if err := ioutil.WriteFile(path, []byte(invalid), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+	_, _, err = Reload(path, current)
+	if want := `account.home.poll_interval: "5m0s" -> "-5m0s": must not be negative`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Reload(...) of invalid config: got %v, want error containing %q", err, want)
+	}
+
+	valid := strings.Replace(testConfig, `unit = "auto"`, `unit = "celsius"`, 1)
+	if err := ioutil.WriteFile(path, []byte(valid), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+	next, changes, err := Reload(path, current)
+	if err != nil {
+		t.Fatalf("Reload(...): unexpected error: %v", err)
+	}
+	if next.Accounts[1].Unit != "celsius" || len(changes) != 1 || changes[0].Key != "account.office.unit" {
+		t.Errorf("Reload(...): got changes %v", changes)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "promobee.toml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, 10*time.Millisecond, func() { changed <- struct{}{} })
+		close(done)
+	}()
+	select {
+	case <-changed:
+		t.Fatalf("Watch(...) called changed before the file changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := ioutil.WriteFile(path, []byte(testConfig+"\n"), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Watch(...) did not call changed after the file changed")
+	}
+	cancel()
+	<-done
+}
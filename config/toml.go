@@ -0,0 +1,420 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the subset of TOML needed by configuration files into nested
+// maps, and the line on which each key, table and array of tables was defined.
+// Tables, arrays of tables, dotted keys, strings, integers, floats, booleans,
+// arrays and inline tables are supported; multi-line strings and dates are not,
+// and are reported as such. As in TOML, a table may only be defined once, and a
+// key only once within it.
+func parseTOML(s string) (map[string]interface{}, keyLines, error) {
+	p := &parser{s: s, line: 1, lines: make(keyLines), headers: make(map[string]int), inline: make(map[string]bool)}
+	root := make(map[string]interface{})
+	current, path := root, ""
+	for {
+		p.skip(true)
+		if p.eof() {
+			return root, p.lines, nil
+		}
+		var err error
+		if p.peek() == '[' {
+			current, path, err = p.header(root)
+		} else {
+			err = p.keyValue(current, path)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+		p.skip(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, nil, fmt.Errorf("line %d: unexpected %q after value", p.line, p.peek())
+		}
+	}
+}
+
+// keyLines are the lines on which keys were defined, by path. Paths are dotted
+// keys, with the index of each table in an array of tables, such as
+// "account[1].labels".
+type keyLines map[string]int
+
+// joinPath appends key to path, quoting it unless it is a bare key.
+func joinPath(path, key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isBareKey(key[i]) {
+			key = strconv.Quote(key)
+			break
+		}
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indexPath is the path of the table at index i of the array at path.
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%v[%d]", path, i)
+}
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+
+	lines keyLines
+	// headers are the lines on which tables were defined by a header, by path.
+	headers map[string]int
+	// inline tables, by path, which may not be extended.
+	inline map[string]bool
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *parser) consume(prefix string) bool {
+	if !strings.HasPrefix(p.s[p.pos:], prefix) {
+		return false
+	}
+	p.pos += len(prefix)
+	return true
+}
+
+// skip whitespace and comments, and newlines if newlines is true.
+func (p *parser) skip(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// header parses a [table] or [[array]] header, and returns the table to which
+// following keys belong, and its path.
+func (p *parser) header(root map[string]interface{}) (map[string]interface{}, string, error) {
+	array := p.consume("[[")
+	if !array {
+		p.consume("[")
+	}
+	keys, err := p.key()
+	if err != nil {
+		return nil, "", err
+	}
+	end := "]"
+	if array {
+		end = "]]"
+	}
+	if !p.consume(end) {
+		return nil, "", fmt.Errorf("expected %q after table name", end)
+	}
+	t, parent, err := p.table(root, "", keys[:len(keys)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	last := keys[len(keys)-1]
+	path := joinPath(parent, last)
+	// A key, rather than a header, defined the table or value at path.
+	if line, ok := p.lines[path]; ok && p.headers[path] == 0 {
+		return nil, "", fmt.Errorf("%q is already defined on line %d", path, line)
+	}
+	if array {
+		tables, ok := t[last].([]interface{})
+		if _, exists := t[last]; exists && !ok {
+			return nil, "", fmt.Errorf("%q is not an array of tables", path)
+		}
+		if !ok {
+			p.lines[path] = p.line
+			p.headers[path] = p.line
+		}
+		next := make(map[string]interface{})
+		t[last] = append(tables, next)
+		path = indexPath(path, len(tables))
+		p.lines[path] = p.line
+		return next, path, nil
+	}
+	if line, ok := p.headers[path]; ok {
+		return nil, "", fmt.Errorf("table %q is already defined on line %d", path, line)
+	}
+	p.headers[path] = p.line
+	p.lines[path] = p.line
+	return p.table(t, parent, []string{last})
+}
+
+// table at the path of keys from t, which is at path, created if necessary. An
+// array of tables in the path refers to its last table. The path of the table
+// is returned with it.
+func (p *parser) table(t map[string]interface{}, path string, keys []string) (map[string]interface{}, string, error) {
+	for _, k := range keys {
+		path = joinPath(path, k)
+		switch v := t[k].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			t[k] = next
+			t = next
+		case map[string]interface{}:
+			if p.inline[path] {
+				return nil, "", fmt.Errorf("inline table %q cannot be extended", path)
+			}
+			t = v
+		case []interface{}:
+			last, ok := v[len(v)-1].(map[string]interface{})
+			path = indexPath(path, len(v)-1)
+			if !ok || p.inline[path] {
+				return nil, "", fmt.Errorf("%q is not a table", path)
+			}
+			t = last
+		default:
+			return nil, "", fmt.Errorf("%q is not a table", path)
+		}
+	}
+	return t, path, nil
+}
+
+// keyValue parses key = value into t, which is at path.
+func (p *parser) keyValue(t map[string]interface{}, path string) error {
+	line := p.line
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if !p.consume("=") {
+		return fmt.Errorf("expected '=' after %q", strings.Join(keys, "."))
+	}
+	p.skip(false)
+	t, path, err = p.table(t, path, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	path = joinPath(path, last)
+	if _, ok := t[last]; ok {
+		if prev, ok := p.lines[path]; ok {
+			return fmt.Errorf("%q is already defined on line %d", path, prev)
+		}
+		return fmt.Errorf("%q is already defined", path)
+	}
+	p.lines[path] = line
+	v, err := p.value(path)
+	if err != nil {
+		return err
+	}
+	t[last] = v
+	return nil
+}
+
+// key parses a dotted key, and any whitespace following it.
+func (p *parser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.eof() {
+			return nil, fmt.Errorf("expected key")
+		}
+		var k string
+		switch p.peek() {
+		case '"', '\'':
+			v, err := p.value("")
+			if err != nil {
+				return nil, err
+			}
+			k = v.(string)
+		default:
+			start := p.pos
+			for !p.eof() && isBareKey(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, fmt.Errorf("expected key, got %q", p.peek())
+			}
+			k = p.s[start:p.pos]
+		}
+		keys = append(keys, k)
+		p.skip(false)
+		if !p.consume(".") {
+			return keys, nil
+		}
+	}
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// dateRx matches the start of TOML dates and times, which are not supported.
+var dateRx = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}|[0-9]{2}:[0-9]{2})`)
+
+// value parses a value of any supported type, which is at path.
+func (p *parser) value(path string) (interface{}, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch p.peek() {
+	case '"':
+		if strings.HasPrefix(p.s[p.pos:], `"""`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.basicString()
+	case '\'':
+		if strings.HasPrefix(p.s[p.pos:], `'''`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		p.pos++
+		end := strings.IndexAny(p.s[p.pos:], "'\n")
+		if end < 0 || p.s[p.pos+end] != '\'' {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		v := p.s[p.pos : p.pos+end]
+		p.pos += end + 1
+		return v, nil
+	case '[':
+		return p.array(path)
+	case '{':
+		return p.inlineTable(path)
+	}
+	start := p.pos
+	for !p.eof() && (isBareKey(p.peek()) || strings.IndexByte("+.:", p.peek()) >= 0) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, fmt.Errorf("expected value, got %q", p.peek())
+	}
+	if dateRx.MatchString(token) {
+		return nil, fmt.Errorf("dates and times are not supported; quote %q to make it a string", token)
+	}
+	if i, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(strings.Replace(token, "_", "", -1), 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// basicString parses a double-quoted string, interpreting escapes.
+func (p *parser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", fmt.Errorf("unterminated string")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.s) {
+					return "", fmt.Errorf("invalid escape \\%c", e)
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", fmt.Errorf("invalid escape \\%c%v", e, p.s[p.pos:p.pos+n])
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// array parses an array at path, which may span lines.
+func (p *parser) array(path string) ([]interface{}, error) {
+	p.pos++
+	a := []interface{}{}
+	for {
+		p.skip(true)
+		if p.consume("]") {
+			return a, nil
+		}
+		v, err := p.value(indexPath(path, len(a)))
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		p.skip(true)
+		if p.consume("]") {
+			return a, nil
+		}
+		if !p.consume(",") {
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// inlineTable parses an inline table at path, which must be on one line.
+func (p *parser) inlineTable(path string) (map[string]interface{}, error) {
+	p.pos++
+	p.inline[path] = true
+	t := make(map[string]interface{})
+	p.skip(false)
+	if p.consume("}") {
+		return t, nil
+	}
+	for {
+		if err := p.keyValue(t, path); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.consume("}") {
+			return t, nil
+		}
+		if !p.consume(",") {
+			return nil, fmt.Errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
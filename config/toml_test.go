@@ -0,0 +1,114 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	got, lines, err := parseTOML(`# A comment.
+title = "promobee" # Another.
+literal = 'C:\path'
+escaped = "tab\there \"quoted\" \u00e9"
+count = 1_000
+hex = 0x1f
+ratio = -0.5
+enabled = true
+list = [
+  "a", # Comments are allowed in arrays.
+  "b",
+]
+inline = { x = 1, "quoted key" = false }
+dotted.key = "v"
+
+[table]
+key = "value"
+
+[[array]]
+name = "first"
+
+[array.sub]
+k = 1
+
+[[array]]
+name = "second"
+`)
+	if err != nil {
+		t.Fatalf("parseTOML(...): unexpected error: %v", err)
+	}
+	want := map[string]interface{}{
+		"title":   "promobee",
+		"literal": `C:\path`,
+		"escaped": "tab\there \"quoted\" é",
+		"count":   int64(1000),
+		"hex":     int64(31),
+		"ratio":   -0.5,
+		"enabled": true,
+		"list":    []interface{}{"a", "b"},
+		"inline":  map[string]interface{}{"x": int64(1), "quoted key": false},
+		"dotted":  map[string]interface{}{"key": "v"},
+		"table":   map[string]interface{}{"key": "value"},
+		"array": []interface{}{
+			map[string]interface{}{"name": "first", "sub": map[string]interface{}{"k": int64(1)}},
+			map[string]interface{}{"name": "second"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML(...):\ngot  %#v\nwant %#v", got, want)
+	}
+	for path, want := range map[string]int{
+		"title":               2,
+		"list":                9,
+		`inline."quoted key"`: 13,
+		"dotted.key":          14,
+		"table":               16,
+		"table.key":           17,
+		"array":               19,
+		"array[0].sub.k":      23,
+		"array[1]":            25,
+		"array[1].name":       26,
+	} {
+		if got := lines[path]; got != want {
+			t.Errorf("parseTOML(...): %v on line %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestParseTOML_errors(t *testing.T) {
+	for _, tt := range []struct {
+		s, want string
+	}{
+		{s: `key`},
+		{s: `key = `},
+		{s: `key = "unterminated`},
+		{s: `key = 'unterminated`},
+		{s: `key = "bad \q escape"`},
+		{s: `key = nope`},
+		{s: `key = 1 2`},
+		{s: `key = [1 2]`},
+		{s: `key = { a = 1`},
+		{s: "key = 1\nkey = 2", want: `line 2: "key" is already defined on line 1`},
+		{s: "key = 1\n[key]", want: `line 2: "key" is already defined on line 1`},
+		{s: "[table\nkey = 1"},
+		{s: "[table]\nkey = 1\n\n[table]\nother = 2", want: `line 4: table "table" is already defined on line 1`},
+		{s: "[[array]]\n[array.sub]\n[array.sub]", want: `line 3: table "array[0].sub" is already defined on line 2`},
+		{s: "[table]\n[[table]]", want: `line 2: "table" is not an array of tables`},
+		{s: "inline = { a = 1 }\n[inline.b]", want: `line 2: inline table "inline" cannot be extended`},
+		{s: "inline = { a = 1 }\ninline.b = 2", want: `line 2: inline table "inline" cannot be extended`},
+		{s: "list = [{ a = 1 }]\n[list.b]", want: `line 2: "list[0]" is not a table`},
+		{s: `key = """multi-line"""`, want: "line 1: multi-line strings are not supported"},
+		{s: "\nkey = '''multi-line'''", want: "line 2: multi-line strings are not supported"},
+		{s: "when = 2020-07-01", want: "line 1: dates and times are not supported"},
+		{s: "when = 07:30:00", want: "line 1: dates and times are not supported"},
+	} {
+		_, _, err := parseTOML(tt.s)
+		if err == nil {
+			t.Errorf("parseTOML(%q): want error, got nil", tt.s)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseTOML(%q): got error %q, want %q", tt.s, err, tt.want)
+		}
+	}
+}
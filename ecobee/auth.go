@@ -0,0 +1,294 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// IsInvalidGrant reports whether err was caused by the API rejecting the refresh
+// token, in which case the application must be registered again. egobee only
+// reports this in the text of the error returned when refreshing the token.
+func IsInvalidGrant(err error) bool {
+	return err != nil && strings.Contains(err.Error(), string(egobee.AuthorizationErrorInvalidGrant))
+}
+
+// Locker is implemented by TokenStorers which may be shared with other
+// processes. While a Locker is locked, no other process may refresh the token,
+// and the store reflects any refresh made by another process before it was
+// locked.
+type Locker interface {
+	Lock() error
+	// UpdateLocked is Update for the caller holding the lock, which refreshed
+	// the token while it was held. Update itself waits for the lock.
+	UpdateLocked(*egobee.TokenRefreshResponse) error
+	Unlock() error
+}
+
+const tokenURL = "/token"
+
+// refreshThreshold is the remaining validity of the access token below which it
+// is refreshed. It must exceed the threshold at which the egobee transport
+// refreshes the token, so that a Locker is always refreshed while locked.
+const refreshThreshold = 30 * time.Second
+
+func needsRefresh(ts egobee.TokenStorer) bool {
+	return ts.AccessToken() == "" || ts.ValidFor() < refreshThreshold
+}
+
+// refreshTimeout bounds a token refresh, which is not cancelled with the request
+// which needed it.
+const refreshTimeout = 30 * time.Second
+
+// refreshToken exchanges the refresh token in the store for new tokens, while
+// holding its lock. If another process has refreshed the token in the meantime,
+// its tokens are used instead. Once sent, the refresh token may have been
+// revoked by the API, so the refresh is completed and stored even if the
+// request which needed it is cancelled, such as when the process is stopping.
+func (c *Client) refreshToken(l Locker) error {
+	if err := l.Lock(); err != nil {
+		return fmt.Errorf("failed locking token store: %v", err)
+	}
+	defer l.Unlock()
+	if !needsRefresh(c.ts) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	trr, err := postToken(ctx, c.api, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {c.ts.RefreshToken()},
+		"client_id":     {c.appID},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to re-authenticate: %v", err)
+	}
+	return l.UpdateLocked(trr)
+}
+
+// authError is an error response from the token endpoint.
+type authError struct {
+	egobee.AuthorizationErrorResponse
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("%v: %v", e.AuthorizationErrorResponse.Error, e.Description)
+}
+
+// postToken posts q to the token endpoint of api. If the API responds with an
+// error, it is returned as an *authError.
+func postToken(ctx context.Context, api string, q url.Values) (*egobee.TokenRefreshResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%v%v?%v", api, tokenURL, q.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	// The token endpoint is not authorized with the access token, so the plain
+	// default client is used.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if (res.StatusCode / 100) != 2 {
+		aer := &authError{}
+		if err := aer.Populate(res.Body); err != nil || aer.AuthorizationErrorResponse.Error == "" {
+			return nil, errors.New(res.Status)
+		}
+		return nil, aer
+	}
+	trr := &egobee.TokenRefreshResponse{}
+	if err := trr.Populate(res.Body); err != nil {
+		return nil, fmt.Errorf("failed decoding token response: %v", err)
+	}
+	return trr, nil
+}
+
+const authorizeURL = "/authorize"
+
+// PinChallenge is the response of the API to a request for PIN authorization.
+// Unlike egobee.PinAuthenticationChallenge, it includes how long the PIN is
+// valid, and how often its authorization may be checked.
+type PinChallenge struct {
+	Pin   string       `json:"ecobeePin"`
+	Code  string       `json:"code"`
+	Scope egobee.Scope `json:"scope"`
+	// ExpiresIn is the number of minutes for which the PIN is valid.
+	ExpiresIn int `json:"expires_in"`
+	// Interval is the minimum number of seconds between checks of whether the
+	// PIN has been authorized.
+	Interval int `json:"interval"`
+
+	issued time.Time
+}
+
+// Expires is the time after which the PIN can no longer be authorized.
+func (p *PinChallenge) Expires() time.Time {
+	return p.issued.Add(time.Duration(p.ExpiresIn) * time.Minute)
+}
+
+// pinSecond is the unit of PinChallenge.Interval, overridable for testing.
+var pinSecond = time.Second
+
+// defaultPinInterval is used if the API does not specify an interval.
+const defaultPinInterval = 30
+
+// slowDownSeconds is added to the interval whenever the API asks for it to be
+// checked less often.
+const slowDownSeconds = 5
+
+// RequestPin for appID from the API at api, which defaults to the ecobee API.
+// The PIN must be entered into the ecobee web portal by the account owner,
+// after which it is exchanged for tokens by AwaitPin.
+func RequestPin(ctx context.Context, api, appID string, scope egobee.Scope) (*PinChallenge, error) {
+	if api == "" {
+		api = ecobeeAPIHost
+	}
+	q := url.Values{
+		"response_type": {"ecobeePin"},
+		"client_id":     {appID},
+		"scope":         {string(scope)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%v%v?%v", api, authorizeURL, q.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if (res.StatusCode / 100) != 2 {
+		aer := &authError{}
+		if err := aer.Populate(res.Body); err != nil || aer.AuthorizationErrorResponse.Error == "" {
+			return nil, fmt.Errorf("unable to request PIN: %v", res.Status)
+		}
+		return nil, fmt.Errorf("unable to request PIN: %v", aer)
+	}
+	p := &PinChallenge{issued: time.Now()}
+	if err := json.NewDecoder(res.Body).Decode(p); err != nil {
+		return nil, fmt.Errorf("failed decoding PIN response: %v", err)
+	}
+	return p, nil
+}
+
+// AwaitPin checks whether p has been authorized, at the interval requested by
+// the API, until it has, it expires or ctx is done. Once authorized, the tokens
+// for the application are returned.
+func AwaitPin(ctx context.Context, api, appID string, p *PinChallenge) (*egobee.TokenRefreshResponse, error) {
+	if api == "" {
+		api = ecobeeAPIHost
+	}
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPinInterval
+	}
+	expires := p.Expires()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(interval) * pinSecond):
+		}
+		trr, err := postToken(ctx, api, url.Values{
+			"grant_type": {"ecobeePin"},
+			"code":       {p.Code},
+			"client_id":  {appID},
+		})
+		if err == nil {
+			return trr, nil
+		}
+		aer, ok := err.(*authError)
+		if !ok {
+			return nil, fmt.Errorf("unable to authenticate: %v", err)
+		}
+		switch aer.AuthorizationErrorResponse.Error {
+		case egobee.AuthorizationErrorAuthorizationPending:
+		case egobee.AuthorizationErrorSlowDown:
+			interval += slowDownSeconds
+		default:
+			return nil, fmt.Errorf("unable to authenticate: %v", err)
+		}
+		if p.ExpiresIn > 0 && time.Now().After(expires) {
+			return nil, fmt.Errorf("unable to authenticate: PIN %v expired at %v", p.Pin, expires.Format(time.Kitchen))
+		}
+	}
+}
+
+// savedPin is a PinChallenge saved by SavePin, along with what is needed to
+// resume it.
+type savedPin struct {
+	*PinChallenge
+	Issued time.Time `json:"issued"`
+	API    string    `json:"api"`
+	AppID  string    `json:"client_id"`
+}
+
+// PinFile keeps the PinChallenge saved by SavePin. ReadFile returns an error for
+// which os.IsNotExist holds if nothing has been saved.
+type PinFile interface {
+	ReadFile() ([]byte, error)
+	WriteFile([]byte) error
+}
+
+// SavePin saves p, requested for appID from api, to f, so that if waiting for it
+// to be authorized is interrupted, it may be resumed with ResumePin. p may be
+// exchanged for tokens until it expires, so f must keep it private.
+func SavePin(f PinFile, api, appID string, p *PinChallenge) error {
+	b, err := json.Marshal(&savedPin{PinChallenge: p, Issued: p.issued, API: api, AppID: appID})
+	if err != nil {
+		return err
+	}
+	return f.WriteFile(b)
+}
+
+// ResumePin returns the PinChallenge saved by SavePin to f, if it was requested
+// for appID with scope from api, and has not yet expired. Otherwise, including
+// if nothing has been saved, it returns nil.
+func ResumePin(f PinFile, api, appID string, scope egobee.Scope) (*PinChallenge, error) {
+	b, err := f.ReadFile()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := &savedPin{}
+	if err := json.Unmarshal(b, saved); err != nil {
+		return nil, fmt.Errorf("invalid pending authorization: %v", err)
+	}
+	p := saved.PinChallenge
+	if p == nil || saved.API != api || saved.AppID != appID || p.Scope != scope {
+		return nil, nil
+	}
+	p.issued = saved.Issued
+	if p.ExpiresIn > 0 && time.Now().After(p.Expires()) {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// lockingTransport refreshes the access token of a Locker while it is locked,
+// before the egobee transport would otherwise refresh it without locking.
+type lockingTransport struct {
+	c    *Client
+	l    Locker
+	next http.RoundTripper
+}
+
+func (t *lockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if needsRefresh(t.c.ts) {
+		if err := t.c.refreshToken(t.l); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
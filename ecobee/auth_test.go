@@ -0,0 +1,268 @@
+package ecobee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestIsInvalidGrant(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			t.Errorf("unexpected request for %v", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "The authorization grant is invalid."}`)
+	}))
+	defer srv.Close()
+	// The access token has expired, so the next request refreshes it.
+	ts := egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{
+		RefreshToken: "revoked",
+		ExpiresIn:    egobee.TokenDuration{Duration: -time.Hour},
+	})
+	c := New("app", ts, &egobee.Options{APIHost: srv.URL})
+
+	_, err := c.Thermostats(context.Background(), &egobee.Selection{})
+	if !IsInvalidGrant(err) {
+		t.Errorf("IsInvalidGrant(%v): got false, want true", err)
+	}
+	if IsInvalidGrant(errors.New("connection refused")) || IsInvalidGrant(nil) {
+		t.Errorf("IsInvalidGrant(...): got true for an unrelated error")
+	}
+}
+
+// lockingStore is a Locker backed by memory. onLock is called whenever it is
+// locked, standing in for a reload from disk.
+type lockingStore struct {
+	egobee.TokenStorer
+	locked, unlocked int
+	onLock           func(egobee.TokenStorer)
+}
+
+func (s *lockingStore) Lock() error {
+	s.locked++
+	if s.onLock != nil {
+		s.onLock(s.TokenStorer)
+	}
+	return nil
+}
+
+func (s *lockingStore) UpdateLocked(r *egobee.TokenRefreshResponse) error {
+	return s.Update(r)
+}
+
+func (s *lockingStore) Unlock() error {
+	s.unlocked++
+	return nil
+}
+
+func TestClient_refreshesLocker(t *testing.T) {
+	var refreshes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokenURL:
+			refreshes++
+			if got := r.URL.Query().Get("refresh_token"); got != "r1" {
+				t.Errorf("refresh_token: got %q, want %q", got, "r1")
+			}
+			fmt.Fprint(w, `{"access_token": "a2", "refresh_token": "r2", "expires_in": 3600}`)
+		case thermostatURL:
+			if got := r.Header.Get("Authorization"); got != "Bearer a2" {
+				t.Errorf("Authorization: got %q, want %q", got, "Bearer a2")
+			}
+			fmt.Fprint(w, testThermostatResponse)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	expired := &egobee.TokenRefreshResponse{AccessToken: "a1", RefreshToken: "r1"}
+	ts := &lockingStore{TokenStorer: egobee.NewMemoryTokenStore(expired)}
+	c := New("app", ts, &egobee.Options{APIHost: srv.URL})
+	if _, err := c.Thermostats(context.Background(), &egobee.Selection{}); err != nil {
+		t.Fatalf("Thermostats(...): unexpected error: %v", err)
+	}
+	if refreshes != 1 || ts.locked != 1 || ts.unlocked != 1 {
+		t.Errorf("got %d refreshes, %d locks and %d unlocks, want 1 of each", refreshes, ts.locked, ts.unlocked)
+	}
+	if got := ts.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken(): got %q, want %q", got, "r2")
+	}
+
+	// If another process refreshed the token while this one waited for the lock,
+	// its token is used.
+	ts = &lockingStore{
+		TokenStorer: egobee.NewMemoryTokenStore(expired),
+		onLock: func(s egobee.TokenStorer) {
+			s.Update(&egobee.TokenRefreshResponse{AccessToken: "a2", RefreshToken: "r2", ExpiresIn: egobee.TokenDuration{Duration: time.Hour}})
+		},
+	}
+	c = New("app", ts, &egobee.Options{APIHost: srv.URL})
+	if _, err := c.Thermostats(context.Background(), &egobee.Selection{}); err != nil {
+		t.Fatalf("Thermostats(...): unexpected error: %v", err)
+	}
+	if refreshes != 1 {
+		t.Errorf("got %d refreshes, want none after another process refreshed", refreshes-1)
+	}
+}
+
+func TestClient_refreshOutlivesRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokenURL:
+			// The process is stopped once the refresh token has been sent, and
+			// revoked by the API.
+			cancel()
+			fmt.Fprint(w, `{"access_token": "a2", "refresh_token": "r2", "expires_in": 3600}`)
+		default:
+			fmt.Fprint(w, testThermostatResponse)
+		}
+	}))
+	defer srv.Close()
+
+	ts := &lockingStore{TokenStorer: egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{AccessToken: "a1", RefreshToken: "r1"})}
+	c := New("app", ts, &egobee.Options{APIHost: srv.URL})
+	if _, err := c.Thermostats(ctx, &egobee.Selection{}); err == nil {
+		t.Errorf("Thermostats(...) with cancelled context: want error, got nil")
+	}
+	if got := ts.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken(): got %q, want %q stored despite the cancellation", got, "r2")
+	}
+}
+
+func TestAwaitPin(t *testing.T) {
+	defer func(s time.Duration) { pinSecond = s }(pinSecond)
+	pinSecond = time.Millisecond
+
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/authorize":
+			if got := q.Get("scope"); got != "smartRead" {
+				t.Errorf("authorize: got scope %q, want %q", got, "smartRead")
+			}
+			fmt.Fprint(w, `{"ecobeePin": "abc9", "code": "authcode", "scope": "smartRead", "expires_in": 9, "interval": 2}`)
+		case "/token":
+			if got := q.Get("code"); got != "authcode" {
+				t.Errorf("token: got code %q, want %q", got, "authcode")
+			}
+			polls++
+			switch polls {
+			case 1:
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": "authorization_pending", "error_description": "Waiting for user to authorize application."}`)
+			case 2:
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": "slow_down", "error_description": "Slow down."}`)
+			default:
+				fmt.Fprint(w, `{"access_token": "access", "refresh_token": "refresh", "expires_in": 3599}`)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := RequestPin(context.Background(), srv.URL, "app", egobee.ScopeSmartRead)
+	if err != nil {
+		t.Fatalf("RequestPin(...): unexpected error: %v", err)
+	}
+	if p.Pin != "abc9" || p.Interval != 2 {
+		t.Errorf("RequestPin(...): got %+v", p)
+	}
+	if got := time.Until(p.Expires()); got < 8*time.Minute || got > 9*time.Minute {
+		t.Errorf("Expires(): got %v from now, want 9m", got)
+	}
+	trr, err := AwaitPin(context.Background(), srv.URL, "app", p)
+	if err != nil {
+		t.Fatalf("AwaitPin(...): unexpected error: %v", err)
+	}
+	if trr.RefreshToken != "refresh" || polls != 3 {
+		t.Errorf("AwaitPin(...): got refresh token %q after %d polls, want %q after 3", trr.RefreshToken, polls, "refresh")
+	}
+}
+
+func TestAwaitPin_expired(t *testing.T) {
+	defer func(s time.Duration) { pinSecond = s }(pinSecond)
+	pinSecond = time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "authorization_expired", "error_description": "The authorization has expired."}`)
+	}))
+	defer srv.Close()
+
+	_, err := AwaitPin(context.Background(), srv.URL, "app", &PinChallenge{Code: "authcode", Interval: 1})
+	if err == nil || !strings.Contains(err.Error(), "authorization_expired") {
+		t.Errorf("AwaitPin(...): got %v, want authorization_expired", err)
+	}
+}
+
+// memPinFile is a PinFile in memory.
+type memPinFile struct {
+	b []byte
+}
+
+func (f *memPinFile) ReadFile() ([]byte, error) {
+	if f.b == nil {
+		return nil, os.ErrNotExist
+	}
+	return f.b, nil
+}
+
+func (f *memPinFile) WriteFile(b []byte) error {
+	f.b = b
+	return nil
+}
+
+func TestResumePin(t *testing.T) {
+	f := &memPinFile{}
+	if p, err := ResumePin(f, "api", "app", egobee.ScopeSmartRead); p != nil || err != nil {
+		t.Errorf("ResumePin(...) without saved PIN: got %v, %v, want nil, nil", p, err)
+	}
+	issued := time.Now().Add(-5 * time.Minute).Round(0)
+	saved := &PinChallenge{Pin: "abc9", Code: "authcode", Scope: egobee.ScopeSmartRead, ExpiresIn: 9, Interval: 30, issued: issued}
+	if err := SavePin(f, "api", "app", saved); err != nil {
+		t.Fatalf("SavePin(...): unexpected error: %v", err)
+	}
+	p, err := ResumePin(f, "api", "app", egobee.ScopeSmartRead)
+	if err != nil {
+		t.Fatalf("ResumePin(...): unexpected error: %v", err)
+	}
+	if p == nil || p.Pin != "abc9" || p.Code != "authcode" || p.Interval != 30 || !p.Expires().Equal(saved.Expires()) {
+		t.Errorf("ResumePin(...): got %+v, want %+v", p, saved)
+	}
+
+	for _, tt := range []struct {
+		name, api, appID string
+		scope            egobee.Scope
+	}{
+		{name: "other API", api: "other", appID: "app", scope: egobee.ScopeSmartRead},
+		{name: "other application", api: "api", appID: "other", scope: egobee.ScopeSmartRead},
+		{name: "other scope", api: "api", appID: "app", scope: egobee.ScopeSmartWrite},
+	} {
+		if p, err := ResumePin(f, tt.api, tt.appID, tt.scope); p != nil || err != nil {
+			t.Errorf("ResumePin(...) for %v: got %v, %v, want nil, nil", tt.name, p, err)
+		}
+	}
+
+	saved.issued = time.Now().Add(-10 * time.Minute)
+	if err := SavePin(f, "api", "app", saved); err != nil {
+		t.Fatalf("SavePin(...): unexpected error: %v", err)
+	}
+	if p, err := ResumePin(f, "api", "app", egobee.ScopeSmartRead); p != nil || err != nil {
+		t.Errorf("ResumePin(...) for expired PIN: got %v, %v, want nil, nil", p, err)
+	}
+}
@@ -0,0 +1,213 @@
+// Package ecobee supplements egobee with the parts of the ecobee API which
+// promobee needs, but which egobee does not (yet) model correctly.
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+const (
+	ecobeeAPIHost = "https://api.ecobee.com"
+
+	// These API Paths are relative to the API Host above.
+	thermostatURL = "/1/thermostat"
+
+	requestContentType = "application/json; charset=utf-8"
+)
+
+// Client for the ecobee API. It embeds an egobee.Client, so all of the egobee
+// functionality remains available, and requests made by this package share the
+// egobee authorizing transport.
+type Client struct {
+	*egobee.Client
+	api   string
+	appID string
+	ts    egobee.TokenStorer
+}
+
+// New Client. opts may be nil. If ts is a Locker, the access token is refreshed
+// while it is locked.
+func New(appID string, ts egobee.TokenStorer, opts *egobee.Options) *Client {
+	api := ecobeeAPIHost
+	if opts != nil && opts.APIHost != "" {
+		api = opts.APIHost
+	}
+	c := &Client{
+		Client: egobee.New(appID, ts, opts),
+		api:    api,
+		appID:  appID,
+		ts:     ts,
+	}
+	if l, ok := ts.(Locker); ok {
+		c.Client.Transport = &lockingTransport{c: c, l: l, next: c.Client.Transport}
+	}
+	return c
+}
+
+// WrapTransport replaces the transport of the Client with the result of wrap,
+// which is passed the current transport. The current transport authorizes each
+// request, refreshing the access token first if necessary.
+func (c *Client) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	c.Client.Transport = wrap(c.Client.Transport)
+}
+
+// Flusher is implemented by TokenStorers which may be in the middle of a token
+// refresh or Update by another goroutine. Flush waits for it to be stored.
+type Flusher interface {
+	Flush() error
+}
+
+// FlushTokenStore waits for any token refresh in progress to be stored, so that
+// the process may exit without losing the refreshed token. It is a no-op unless
+// the store implements Flusher.
+func (c *Client) FlushTokenStore() error {
+	if f, ok := c.ts.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// TokenValidFor reports how much longer the current access token is valid. It
+// is negative once the token has expired.
+func (c *Client) TokenValidFor() time.Duration {
+	return c.ts.ValidFor()
+}
+
+func (c *Client) url(apiPath string) string {
+	return c.api + apiPath
+}
+
+// getRequest creates a GET request for the API at apiPath with query, which is
+// cancelled along with ctx.
+func (c *Client) getRequest(ctx context.Context, apiPath string, query url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%v?%v", c.url(apiPath), query.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Add("Content-Type", requestContentType)
+	return req, nil
+}
+
+// selectionRequest creates a GET request for the API at apiPath, with the
+// selection serialized in the format expected by the ecobee API. If pageNumber
+// is non-zero, that page of the response is requested.
+func (c *Client) selectionRequest(ctx context.Context, apiPath string, selection *egobee.Selection, pageNumber int) (*http.Request, error) {
+	q := struct {
+		Selection *egobee.Selection `json:"selection"`
+		Page      *pageRequest      `json:"page,omitempty"`
+	}{Selection: selection}
+	if pageNumber != 0 {
+		q.Page = &pageRequest{Page: pageNumber}
+	}
+	qb, err := json.Marshal(&q)
+	if err != nil {
+		return nil, err
+	}
+	return c.getRequest(ctx, apiPath, url.Values{"json": {string(qb)}})
+}
+
+// doJSON performs req, and decodes the JSON response into v.
+func (c *Client) doJSON(req *http.Request, v interface{}) error {
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if (res.StatusCode / 100) != 2 {
+		return fmt.Errorf("non-ok status response from API: %v %v", res.StatusCode, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON: %v", err)
+	}
+	return nil
+}
+
+// status is included in most API responses.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/objects/Status.shtml
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *status) err() error {
+	if s.Code == 0 {
+		return nil
+	}
+	return fmt.Errorf("API returned status %d: %v", s.Code, s.Message)
+}
+
+// page is used for paging in some APIs.
+type page struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"totalPages"`
+	PageSize   int `json:"pageSize"`
+	Total      int `json:"total"`
+}
+
+// pageRequest selects the page of a paged response to return.
+type pageRequest struct {
+	Page int `json:"page"`
+}
+
+// See https://www.ecobee.com/home/developer/api/documentation/v1/operations/get-thermostats.shtml
+type pagedThermostatResponse struct {
+	Page        page          `json:"page,omitempty"`
+	Thermostats []*Thermostat `json:"thermostatList,omitempty"`
+	Status      status        `json:"status,omitempty"`
+}
+
+// thermostatsPage retrieves a single page of the Thermostats matching
+// selection. Pages are numbered from 1.
+func (c *Client) thermostatsPage(ctx context.Context, selection *egobee.Selection, pageNumber int) (*pagedThermostatResponse, error) {
+	req, err := c.selectionRequest(ctx, thermostatURL, selection, pageNumber)
+	if err != nil {
+		return nil, err
+	}
+	ptr := &pagedThermostatResponse{}
+	if err := c.doJSON(req, ptr); err != nil {
+		return nil, err
+	}
+	if err := ptr.Status.err(); err != nil {
+		return nil, err
+	}
+	return ptr, nil
+}
+
+// PagedThermostats returns all Thermostat objects which match selection,
+// fetching every page of the response, along with the number of pages fetched.
+// If a page after the first cannot be fetched, the Thermostats from the pages
+// before it are returned along with the error.
+func (c *Client) PagedThermostats(ctx context.Context, selection *egobee.Selection) ([]*Thermostat, int, error) {
+	var thermostats []*Thermostat
+	for pageNumber := 1; ; pageNumber++ {
+		ptr, err := c.thermostatsPage(ctx, selection, pageNumber)
+		if err != nil {
+			if pageNumber > 1 {
+				err = fmt.Errorf("failed fetching page %d: %v", pageNumber, err)
+			}
+			return thermostats, pageNumber - 1, err
+		}
+		thermostats = append(thermostats, ptr.Thermostats...)
+		if pageNumber >= ptr.Page.TotalPages {
+			return thermostats, pageNumber, nil
+		}
+	}
+}
+
+// Thermostats returns all Thermostat objects which match selection, from every
+// page of the response.
+func (c *Client) Thermostats(ctx context.Context, selection *egobee.Selection) ([]*Thermostat, error) {
+	thermostats, _, err := c.PagedThermostats(ctx, selection)
+	if err != nil {
+		return nil, err
+	}
+	return thermostats, nil
+}
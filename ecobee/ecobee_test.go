@@ -0,0 +1,160 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+const testThermostatResponse = `{
+  "page": {"page": 1, "totalPages": 1, "pageSize": 1, "total": 1},
+  "thermostatList": [{
+    "identifier": "123456789",
+    "name": "Home",
+    "extendedRuntime": {
+      "lastReadingTimestamp": "2020-07-01 12:10:00",
+      "runtimeDate": "2020-07-01",
+      "runtimeInterval": 146,
+      "actualTemperature": [752, 751, 750],
+      "hvacMode": ["cool", "cool", "cool"],
+      "cool1": [0, 300, 210],
+      "fan": [0, 300, 240]
+    }
+  }],
+  "status": {"code": 0, "message": ""}
+}`
+
+// testClient returns a Client for a fake API served by h. The returned server
+// must be closed by the caller.
+func testClient(h http.HandlerFunc) (*Client, *httptest.Server) {
+	srv := httptest.NewServer(h)
+	ts := egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{
+		AccessToken: "access",
+		ExpiresIn:   egobee.TokenDuration{Duration: time.Hour},
+	})
+	return New("app", ts, &egobee.Options{APIHost: srv.URL}), srv
+}
+
+func TestClientThermostats(t *testing.T) {
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != thermostatURL {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("incorrect Authorization header; got %q", got)
+		}
+		fmt.Fprint(w, testThermostatResponse)
+	})
+	defer srv.Close()
+
+	got, err := c.Thermostats(context.Background(), &egobee.Selection{SelectionType: egobee.SelectionTypeRegistered})
+	if err != nil {
+		t.Fatalf("Thermostats(...): unexpected error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Thermostats(...): got %d thermostats, want 1", len(got))
+	}
+	if got[0].Identifier != "123456789" || got[0].Name != "Home" {
+		t.Errorf("Thermostats(...): embedded fields not decoded; got %+v", got[0].Thermostat)
+	}
+	if want := []int{0, 300, 210}; fmt.Sprint(got[0].ExtendedRuntime.Cool1) != fmt.Sprint(want) {
+		t.Errorf("Thermostats(...): ExtendedRuntime.Cool1 got %v, want %v", got[0].ExtendedRuntime.Cool1, want)
+	}
+}
+
+// pagedHandler serves /1/thermostat with one thermostat per page, failing to
+// serve page failPage.
+func pagedHandler(t *testing.T, totalPages, failPage int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := &struct {
+			Page *pageRequest `json:"page"`
+		}{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("json")), q); err != nil {
+			t.Errorf("failed decoding request: %v", err)
+		}
+		n := 1
+		if q.Page != nil {
+			n = q.Page.Page
+		}
+		if n == failPage {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"page": {"page": %d, "totalPages": %d}, "thermostatList": [{"identifier": "t%d"}]}`, n, totalPages, n)
+	}
+}
+
+func TestClientPagedThermostats(t *testing.T) {
+	c, srv := testClient(pagedHandler(t, 3, 0))
+	defer srv.Close()
+
+	got, pages, err := c.PagedThermostats(context.Background(), &egobee.Selection{SelectionType: egobee.SelectionTypeRegistered})
+	if err != nil {
+		t.Fatalf("PagedThermostats(...): unexpected error: %v", err)
+	}
+	if pages != 3 {
+		t.Errorf("PagedThermostats(...): got %d pages, want 3", pages)
+	}
+	var ids []string
+	for _, th := range got {
+		ids = append(ids, th.Identifier)
+	}
+	if want := "[t1 t2 t3]"; fmt.Sprint(ids) != want {
+		t.Errorf("PagedThermostats(...): got %v, want %v", ids, want)
+	}
+}
+
+func TestClientPagedThermostats_laterPageFails(t *testing.T) {
+	c, srv := testClient(pagedHandler(t, 3, 3))
+	defer srv.Close()
+
+	got, pages, err := c.PagedThermostats(context.Background(), &egobee.Selection{SelectionType: egobee.SelectionTypeRegistered})
+	if err == nil {
+		t.Fatalf("PagedThermostats(...): want error, got nil")
+	}
+	if pages != 2 || len(got) != 2 {
+		t.Errorf("PagedThermostats(...): got %d pages and %d thermostats, want 2 of each", pages, len(got))
+	}
+
+	if got, err := c.Thermostats(context.Background(), &egobee.Selection{SelectionType: egobee.SelectionTypeRegistered}); err == nil || got != nil {
+		t.Errorf("Thermostats(...): want nil and error, got %v, %v", got, err)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestClientWrapTransport(t *testing.T) {
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Authorization header: got %q, want %q", got, "Bearer access")
+		}
+		fmt.Fprint(w, testThermostatResponse)
+	})
+	defer srv.Close()
+
+	var paths []string
+	c.WrapTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			paths = append(paths, req.URL.Path)
+			return next.RoundTrip(req)
+		})
+	})
+	if _, err := c.Thermostats(context.Background(), &egobee.Selection{}); err != nil {
+		t.Fatalf("Thermostats(...): unexpected error: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != thermostatURL {
+		t.Errorf("wrapped transport saw %v, want [%v]", paths, thermostatURL)
+	}
+	if got := c.TokenValidFor(); got <= 0 || got > time.Hour {
+		t.Errorf("TokenValidFor(): got %v, want (0, 1h]", got)
+	}
+}
@@ -0,0 +1,305 @@
+package ecobee
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fixture is a response of the ecobee API, saved by a Recorder along with the
+// request for which it was made. Credentials are redacted.
+type Fixture struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Query of the request, other than the json parameter.
+	Query map[string]string `json:"query,omitempty"`
+	// JSON parameter of the request, which holds the selection of most requests.
+	JSON json.RawMessage `json:"json,omitempty"`
+
+	Status int `json:"status"`
+	// Body of the response, if it is JSON, or Text if it is not.
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// redacted is the value of every credential in a Fixture.
+const redacted = "REDACTED"
+
+// credentials are the query parameters and JSON keys which are redacted.
+var credentials = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"code":          true,
+	"client_id":     true,
+}
+
+// topLevelCredentials are only credentials at the top level of a request or
+// response, so that the codes of sensors are kept.
+var topLevelCredentials = map[string]bool{"code": true}
+
+// redact the credentials anywhere in v, which was decoded from JSON.
+// Credentials are strings, unlike the code of a status.
+func redact(v interface{}) interface{} {
+	return redactNested(v, true)
+}
+
+func redactNested(v interface{}, top bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if _, ok := e.(string); ok && credentials[k] && (top || !topLevelCredentials[k]) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactNested(e, false)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactNested(e, false)
+		}
+	}
+	return v
+}
+
+// redactJSON returns b with credentials redacted and keys sorted, or false if b
+// is not JSON.
+func redactJSON(b []byte, indent bool) (json.RawMessage, bool) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil || d.More() {
+		return nil, false
+	}
+	var out []byte
+	var err error
+	if indent {
+		out, err = json.MarshalIndent(redact(v), "", "  ")
+	} else {
+		out, err = json.Marshal(redact(v))
+	}
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// request fields of a Fixture for the request to u.
+func (f *Fixture) request(method string, u *url.URL) {
+	f.Method, f.Path = method, u.Path
+	for k, vs := range u.Query() {
+		if len(vs) < 1 {
+			continue
+		}
+		if k == "json" {
+			if j, ok := redactJSON([]byte(vs[0]), false); ok {
+				f.JSON = j
+				continue
+			}
+		}
+		if f.Query == nil {
+			f.Query = make(map[string]string)
+		}
+		f.Query[k] = vs[0]
+		if credentials[k] {
+			f.Query[k] = redacted
+		}
+	}
+}
+
+// key identifies the request of the Fixture, regardless of parameter order and
+// credentials.
+func (f *Fixture) key() string {
+	q := make(url.Values)
+	for k, v := range f.Query {
+		q.Set(k, v)
+	}
+	var j bytes.Buffer
+	json.Compact(&j, f.JSON)
+	return fmt.Sprintf("%v %v?%v %s", f.Method, f.Path, q.Encode(), j.Bytes())
+}
+
+// Recorder saves every response of the ecobee API as a Fixture, in a file of
+// its own, so that it may be served by a Replayer.
+type Recorder struct {
+	dir string
+
+	mu sync.Mutex // serializes writes, and protects n
+	// n is the number of fixtures in dir, which orders their file names.
+	n int
+}
+
+// NewRecorder saving fixtures in dir, which is created if necessary. Fixtures
+// already in dir are kept, and those recorded are replayed after them.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, n: len(existing)}, nil
+}
+
+// Transport returns a RoundTripper which records the responses to requests made
+// by next. It may be passed to Client.WrapTransport.
+func (r *Recorder) Transport(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{r: r, next: next}
+}
+
+type recordingTransport struct {
+	r    *Recorder
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return res, nil
+	}
+	f := &Fixture{Status: res.StatusCode}
+	f.request(req.Method, req.URL)
+	if body, ok := redactJSON(b, true); ok {
+		f.Body = body
+	} else {
+		f.Text = string(b)
+	}
+	if err := t.r.save(f); err != nil {
+		log.Printf("Error recording response to %v %v: %v", req.Method, req.URL.Path, err)
+	}
+	return res, nil
+}
+
+// save f in a new file, named so that files sort in the order recorded.
+func (r *Recorder) save(f *Fixture) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := fmt.Sprintf("%06d-%v%v.json", r.n+1, f.Method, strings.Replace(f.Path, "/", "-", -1))
+	if err := ioutil.WriteFile(filepath.Join(r.dir, name), append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	r.n++
+	return nil
+}
+
+// fixtures served in order, after which the last is repeated.
+type fixtures struct {
+	list []*Fixture
+	next int
+}
+
+func (s *fixtures) pop() *Fixture {
+	f := s.list[s.next]
+	if s.next < len(s.list)-1 {
+		s.next++
+	}
+	return f
+}
+
+// Replayer is a http.Handler which fakes the ecobee API by serving the fixtures
+// saved by a Recorder, for use as egobee.Options.APIHost. Each request is
+// answered with the fixtures recorded for the same request, in the order they
+// were recorded, and then with the last of them again. Requests which were
+// never recorded are answered with the fixtures recorded for the same path, so
+// that fixtures may be reused after the selection changes. Access tokens are
+// always granted.
+type Replayer struct {
+	mu        sync.Mutex // protects following members
+	byRequest map[string]*fixtures
+	byPath    map[string]*fixtures
+}
+
+// NewReplayer serving the fixtures in dir, as saved by a Recorder.
+func NewReplayer(dir string) (*Replayer, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	r := &Replayer{
+		byRequest: make(map[string]*fixtures),
+		byPath:    make(map[string]*fixtures),
+	}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f := &Fixture{}
+		if err := json.Unmarshal(b, f); err != nil {
+			return nil, fmt.Errorf("invalid fixture %q: %v", path, err)
+		}
+		r.add(r.byRequest, f.key(), f)
+		r.add(r.byPath, f.Method+" "+f.Path, f)
+	}
+	return r, nil
+}
+
+func (r *Replayer) add(m map[string]*fixtures, key string, f *Fixture) {
+	s, ok := m[key]
+	if !ok {
+		s = &fixtures{}
+		m[key] = s
+	}
+	s.list = append(s.list, f)
+}
+
+// find the next fixture for req, or nil if there is none.
+func (r *Replayer) find(req *http.Request) *Fixture {
+	f := &Fixture{}
+	f.request(req.Method, req.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.byRequest[f.key()]; ok {
+		return s.pop()
+	}
+	if s, ok := r.byPath[req.Method+" "+req.URL.Path]; ok {
+		return s.pop()
+	}
+	return nil
+}
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f := r.find(req)
+	if f == nil && req.URL.Path == "/token" {
+		f = &Fixture{
+			Status: http.StatusOK,
+			Body:   json.RawMessage(`{"access_token": "replay", "token_type": "Bearer", "refresh_token": "replay", "expires_in": 3600, "scope": "smartWrite"}`),
+		}
+	}
+	if f == nil {
+		http.Error(w, fmt.Sprintf("no fixture for %v %v", req.Method, req.URL.Path), http.StatusNotFound)
+		return
+	}
+	if f.Body != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.Status)
+		w.Write(f.Body)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(f.Status)
+	w.Write([]byte(f.Text))
+}
@@ -0,0 +1,126 @@
+package ecobee
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	revision := 0
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case thermostatSummaryURL:
+			revision++
+			fmt.Fprintf(w, `{"revisionList": ["123:Home:true:t%d:a:r:i"], "thermostatCount": 1, "status": {"code": 0}}`, revision)
+		case thermostatURL:
+			fmt.Fprint(w, testThermostatResponse)
+		case "/echo":
+			fmt.Fprintf(w, `{"access_token": "secret", "nested": [{"refresh_token": "secret", "ok": 1, "code": "VDBZ"}]}`)
+		default:
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}
+	})
+	defer srv.Close()
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("NewRecorder(...): unexpected error: %v", err)
+	}
+	c.WrapTransport(rec.Transport)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := c.ThermostatSummary(ctx, nil); err != nil {
+			t.Fatalf("ThermostatSummary(...): unexpected error: %v", err)
+		}
+	}
+	if _, err := c.Thermostats(ctx, SelectThermostat("123456789")); err != nil {
+		t.Fatalf("Thermostats(...): unexpected error: %v", err)
+	}
+	res, err := c.Get(srv.URL + "/echo?code=secret&client_id=secret&x=1")
+	if err != nil {
+		t.Fatalf("Get(...): unexpected error: %v", err)
+	}
+	res.Body.Close()
+	res, err = c.Get(srv.URL + "/broken")
+	if err != nil {
+		t.Fatalf("Get(...): unexpected error: %v", err)
+	}
+	if b, _ := ioutil.ReadAll(res.Body); string(b) != "oops\n" {
+		t.Errorf("recorded response body: got %q, want %q", b, "oops\n")
+	}
+	res.Body.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if want := []string{
+		"000001-GET-1-thermostatSummary.json",
+		"000002-GET-1-thermostatSummary.json",
+		"000003-GET-1-thermostat.json",
+		"000004-GET-echo.json",
+		"000005-GET-broken.json",
+	}; len(files) != len(want) || filepath.Base(files[3]) != want[3] {
+		t.Fatalf("recorded %v, want %v", files, want)
+	}
+	echo, _ := ioutil.ReadFile(files[3])
+	if strings.Contains(string(echo), "secret") || !strings.Contains(string(echo), `"x": "1"`) || !strings.Contains(string(echo), `"code": "VDBZ"`) {
+		t.Errorf("credentials not redacted from fixture:\n%s", echo)
+	}
+
+	// Replay the fixtures to a client which knows none of the credentials.
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("NewReplayer(...): unexpected error: %v", err)
+	}
+	replay := httptest.NewServer(replayer)
+	defer replay.Close()
+	ts := egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{RefreshToken: "expired"})
+	c = New("other", ts, &egobee.Options{APIHost: replay.URL})
+	for _, want := range []string{"t1", "t2", "t2"} {
+		s, err := c.ThermostatSummary(ctx, nil)
+		if err != nil {
+			t.Fatalf("replayed ThermostatSummary(...): unexpected error: %v", err)
+		}
+		if revs, _ := Revisions(s); len(revs) != 1 || revs[0].ThermostatRev != want {
+			t.Errorf("replayed ThermostatSummary(...): got %+v, want revision %v", revs, want)
+		}
+	}
+	if ts.AccessToken() != "replay" {
+		t.Errorf("replayed token refresh: got access token %q, want %q", ts.AccessToken(), "replay")
+	}
+	// A selection which was never recorded is answered with the fixture for the
+	// same path.
+	thermostats, err := c.Thermostats(ctx, SelectThermostat("987654321"))
+	if err != nil || len(thermostats) != 1 || thermostats[0].Identifier != "123456789" {
+		t.Errorf("replayed Thermostats(...): got %v, %v", thermostats, err)
+	}
+	res, err = c.Get(replay.URL + "/broken")
+	if err != nil || res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("replayed error: got %v, %v, want status 500", res, err)
+	} else {
+		res.Body.Close()
+	}
+	res, err = c.Get(replay.URL + "/never")
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Errorf("unrecorded request: got %v, %v, want status 404", res, err)
+	} else {
+		res.Body.Close()
+	}
+
+	if _, err := NewReplayer(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("NewReplayer(...) of missing directory: want error, got nil")
+	}
+}
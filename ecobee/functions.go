@@ -0,0 +1,281 @@
+package ecobee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// Function modifies Thermostats when posted to the thermostat API.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/functions/using-functions.shtml
+type Function struct {
+	Type   string      `json:"type"`
+	Params interface{} `json:"params"`
+}
+
+// HoldType determines how long a hold lasts.
+type HoldType string
+
+// Possible HoldTypes.
+const (
+	HoldTypeDateTime       HoldType = "dateTime"
+	HoldTypeNextTransition HoldType = "nextTransition"
+	HoldTypeIndefinite     HoldType = "indefinite"
+	HoldTypeHoldHours      HoldType = "holdHours"
+)
+
+// validate the hold type, which must last hours if HoldTypeHoldHours. The dates
+// of a HoldTypeDateTime hold are validated by the function which takes them.
+func (h HoldType) validate(hours int) error {
+	switch h {
+	case "", HoldTypeDateTime, HoldTypeNextTransition, HoldTypeIndefinite:
+		return nil
+	case HoldTypeHoldHours:
+		if hours < 1 {
+			return fmt.Errorf("holdType %v requires holdHours", h)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid holdType %q", h)
+}
+
+// Layouts of the dates and times of a dateTime hold, in thermostat time.
+const (
+	holdDateLayout = "2006-01-02"
+	holdTimeLayout = "15:04:05"
+)
+
+// SetHoldParams are the parameters of the setHold function. Temperatures are
+// in tenths of a degree Fahrenheit, as everywhere in the API, and are nil if
+// not set; the API ignores a temperature of 0.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/functions/SetHold.shtml
+type SetHoldParams struct {
+	CoolHoldTemp   *int     `json:"coolHoldTemp,omitempty"`
+	HeatHoldTemp   *int     `json:"heatHoldTemp,omitempty"`
+	HoldClimateRef string   `json:"holdClimateRef,omitempty"`
+	StartDate      string   `json:"startDate,omitempty"`
+	StartTime      string   `json:"startTime,omitempty"`
+	EndDate        string   `json:"endDate,omitempty"`
+	EndTime        string   `json:"endTime,omitempty"`
+	HoldType       HoldType `json:"holdType,omitempty"`
+	HoldHours      int      `json:"holdHours,omitempty"`
+	Fan            string   `json:"fan,omitempty"`
+}
+
+// Validate reports whether the parameters describe a usable hold.
+func (p *SetHoldParams) Validate() error {
+	if p.HoldClimateRef == "" && (p.CoolHoldTemp == nil || p.HeatHoldTemp == nil) {
+		return fmt.Errorf("hold requires either holdClimateRef, or both coolHoldTemp and heatHoldTemp")
+	}
+	if p.HoldClimateRef != "" && (p.CoolHoldTemp != nil || p.HeatHoldTemp != nil) {
+		return fmt.Errorf("hold may not have both holdClimateRef and hold temperatures")
+	}
+	switch p.Fan {
+	case "", "auto", "on":
+	default:
+		return fmt.Errorf("invalid fan %q", p.Fan)
+	}
+	if err := p.HoldType.validate(p.HoldHours); err != nil {
+		return err
+	}
+	return p.validateDates()
+}
+
+// validateDates of the hold, which are required by, and only allowed for, a
+// dateTime hold. The hold must end after it starts.
+func (p *SetHoldParams) validateDates() error {
+	if p.HoldType != HoldTypeDateTime {
+		if p.StartDate != "" || p.StartTime != "" || p.EndDate != "" || p.EndTime != "" {
+			return fmt.Errorf("startDate, startTime, endDate and endTime require holdType %v", HoldTypeDateTime)
+		}
+		return nil
+	}
+	if p.StartDate == "" || p.StartTime == "" || p.EndDate == "" || p.EndTime == "" {
+		return fmt.Errorf("holdType %v requires startDate, startTime, endDate and endTime", HoldTypeDateTime)
+	}
+	layout := holdDateLayout + " " + holdTimeLayout
+	start, err := time.Parse(layout, p.StartDate+" "+p.StartTime)
+	if err != nil {
+		return fmt.Errorf("invalid startDate or startTime; must be of the form %v %v", holdDateLayout, holdTimeLayout)
+	}
+	end, err := time.Parse(layout, p.EndDate+" "+p.EndTime)
+	if err != nil {
+		return fmt.Errorf("invalid endDate or endTime; must be of the form %v %v", holdDateLayout, holdTimeLayout)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("hold must end after it starts")
+	}
+	return nil
+}
+
+// SetHold sets a hold on the selected Thermostats.
+func SetHold(p *SetHoldParams) Function {
+	return Function{Type: "setHold", Params: p}
+}
+
+// ResumeProgram removes the currently running event, or all events if
+// resumeAll, resuming the program on the selected Thermostats.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/functions/ResumeProgram.shtml
+func ResumeProgram(resumeAll bool) Function {
+	return Function{Type: "resumeProgram", Params: map[string]bool{"resumeAll": resumeAll}}
+}
+
+// SetOccupiedParams are the parameters of the setOccupied function.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/functions/SetOccupied.shtml
+type SetOccupiedParams struct {
+	Occupied  bool     `json:"occupied"`
+	HoldType  HoldType `json:"holdType,omitempty"`
+	HoldHours int      `json:"holdHours,omitempty"`
+}
+
+// Validate reports whether the parameters are usable. A dateTime hold is not
+// supported, as SetOccupiedParams has no dates.
+func (p *SetOccupiedParams) Validate() error {
+	if p.HoldType == HoldTypeDateTime {
+		return fmt.Errorf("holdType %v is not supported by setOccupied", p.HoldType)
+	}
+	return p.HoldType.validate(p.HoldHours)
+}
+
+// SetOccupied switches the selected Thermostats between occupied and
+// unoccupied. This is only available to EMS thermostats.
+func SetOccupied(p *SetOccupiedParams) Function {
+	return Function{Type: "setOccupied", Params: p}
+}
+
+// AckType is the type of acknowledgement of an Alert.
+type AckType string
+
+// Possible AckTypes.
+const (
+	AckTypeAccept  AckType = "accept"
+	AckTypeDecline AckType = "decline"
+	AckTypeDefer   AckType = "defer"
+)
+
+// AcknowledgeParams are the parameters of the acknowledge function.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/functions/Acknowledge.shtml
+type AcknowledgeParams struct {
+	ThermostatIdentifier string  `json:"thermostatIdentifier"`
+	AckRef               string  `json:"ackRef"`
+	AckType              AckType `json:"ackType"`
+	RemindMeLater        bool    `json:"remindMeLater,omitempty"`
+}
+
+// Validate reports whether the parameters are usable.
+func (p *AcknowledgeParams) Validate() error {
+	if p.ThermostatIdentifier == "" || p.AckRef == "" {
+		return fmt.Errorf("acknowledge requires thermostatIdentifier and ackRef")
+	}
+	switch p.AckType {
+	case AckTypeAccept, AckTypeDecline, AckTypeDefer:
+		return nil
+	}
+	return fmt.Errorf("invalid ackType %q", p.AckType)
+}
+
+// Acknowledge an Alert, identified by its AcknowledgeRef.
+func Acknowledge(p *AcknowledgeParams) Function {
+	return Function{Type: "acknowledge", Params: p}
+}
+
+// SettingsPatch contains the Settings to change. Fields left at their zero
+// value are not changed.
+type SettingsPatch struct {
+	HVACMode     string `json:"hvacMode,omitempty"`
+	FanMinOnTime *int   `json:"fanMinOnTime,omitempty"`
+}
+
+// HVACModes which may be set in a SettingsPatch.
+var HVACModes = []string{"auto", "auxHeatOnly", "cool", "heat", "off"}
+
+// Validate reports whether the patch is usable.
+func (p *SettingsPatch) Validate() error {
+	if p.HVACMode == "" && p.FanMinOnTime == nil {
+		return fmt.Errorf("settings patch changes nothing")
+	}
+	if p.HVACMode != "" {
+		valid := false
+		for _, m := range HVACModes {
+			valid = valid || m == p.HVACMode
+		}
+		if !valid {
+			return fmt.Errorf("invalid hvacMode %q", p.HVACMode)
+		}
+	}
+	if p.FanMinOnTime != nil && (*p.FanMinOnTime < 0 || *p.FanMinOnTime > 60) {
+		return fmt.Errorf("fanMinOnTime must be between 0 and 60 minutes")
+	}
+	return nil
+}
+
+// thermostatUpdate is the request format expected when posting to the
+// thermostat API.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/operations/post-update-thermostats.shtml
+type thermostatUpdate struct {
+	Selection  *egobee.Selection `json:"selection"`
+	Functions  []Function        `json:"functions,omitempty"`
+	Thermostat *thermostatPatch  `json:"thermostat,omitempty"`
+}
+
+// thermostatPatch contains the portions of a Thermostat to change.
+type thermostatPatch struct {
+	Settings *SettingsPatch `json:"settings,omitempty"`
+}
+
+func (c *Client) postThermostatUpdate(ctx context.Context, u *thermostatUpdate) error {
+	body, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(thermostatURL)+"?format=json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Add("Content-Type", requestContentType)
+	res := &struct {
+		Status status `json:"status"`
+	}{}
+	if err := c.doJSON(req, res); err != nil {
+		return err
+	}
+	return res.Status.err()
+}
+
+// CallFunctions calls functions, in order, on the Thermostats matching
+// selection.
+func (c *Client) CallFunctions(ctx context.Context, selection *egobee.Selection, functions ...Function) error {
+	if len(functions) < 1 {
+		return fmt.Errorf("no functions to call")
+	}
+	return c.postThermostatUpdate(ctx, &thermostatUpdate{
+		Selection: selection,
+		Functions: functions,
+	})
+}
+
+// UpdateSettings applies patch to the Settings of the Thermostats matching
+// selection.
+func (c *Client) UpdateSettings(ctx context.Context, selection *egobee.Selection, patch *SettingsPatch) error {
+	if err := patch.Validate(); err != nil {
+		return err
+	}
+	return c.postThermostatUpdate(ctx, &thermostatUpdate{
+		Selection:  selection,
+		Thermostat: &thermostatPatch{Settings: patch},
+	})
+}
+
+// SelectThermostat returns a Selection matching only the Thermostat identified
+// by id.
+func SelectThermostat(id string) *egobee.Selection {
+	return &egobee.Selection{
+		SelectionType:  egobee.SelectionTypeThermostats,
+		SelectionMatch: id,
+	}
+}
@@ -0,0 +1,115 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestSetHoldParamsValidate(t *testing.T) {
+	heat, cool, zero := 680, 760, 0
+	for _, tt := range []struct {
+		name    string
+		p       *SetHoldParams
+		wantErr bool
+	}{
+		{name: "temperatures", p: &SetHoldParams{HeatHoldTemp: &heat, CoolHoldTemp: &cool}},
+		{name: "zero temperature", p: &SetHoldParams{HeatHoldTemp: &zero, CoolHoldTemp: &cool}},
+		{name: "climate", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeIndefinite}},
+		{name: "hours", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeHoldHours, HoldHours: 2}},
+		{name: "dates", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeDateTime, StartDate: "2020-07-01", StartTime: "08:00:00", EndDate: "2020-07-01", EndTime: "17:30:00"}},
+		{name: "nothing", p: &SetHoldParams{}, wantErr: true},
+		{name: "one temperature", p: &SetHoldParams{HeatHoldTemp: &heat}, wantErr: true},
+		{name: "climate and temperatures", p: &SetHoldParams{HoldClimateRef: "away", HeatHoldTemp: &heat, CoolHoldTemp: &cool}, wantErr: true},
+		{name: "hours missing", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeHoldHours}, wantErr: true},
+		{name: "dates missing", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeDateTime, EndDate: "2020-07-01", EndTime: "17:30:00"}, wantErr: true},
+		{name: "bad date", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeDateTime, StartDate: "07/01/2020", StartTime: "08:00:00", EndDate: "2020-07-01", EndTime: "17:30:00"}, wantErr: true},
+		{name: "ends before start", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeDateTime, StartDate: "2020-07-01", StartTime: "08:00:00", EndDate: "2020-07-01", EndTime: "07:30:00"}, wantErr: true},
+		{name: "dates without dateTime", p: &SetHoldParams{HoldClimateRef: "away", HoldType: HoldTypeIndefinite, EndDate: "2020-07-01", EndTime: "17:30:00"}, wantErr: true},
+		{name: "bad hold type", p: &SetHoldParams{HoldClimateRef: "away", HoldType: "forever"}, wantErr: true},
+		{name: "bad fan", p: &SetHoldParams{HoldClimateRef: "away", Fan: "turbo"}, wantErr: true},
+	} {
+		if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSettingsPatchValidate(t *testing.T) {
+	fifteen, tooLong := 15, 61
+	for _, tt := range []struct {
+		name    string
+		p       *SettingsPatch
+		wantErr bool
+	}{
+		{name: "mode", p: &SettingsPatch{HVACMode: "heat"}},
+		{name: "fan", p: &SettingsPatch{FanMinOnTime: &fifteen}},
+		{name: "nothing", p: &SettingsPatch{}, wantErr: true},
+		{name: "bad mode", p: &SettingsPatch{HVACMode: "warm"}, wantErr: true},
+		{name: "bad fan", p: &SettingsPatch{FanMinOnTime: &tooLong}, wantErr: true},
+	} {
+		if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientCallFunctions(t *testing.T) {
+	var got string
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != thermostatURL || r.URL.Query().Get("format") != "json" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got = string(b)
+		fmt.Fprint(w, `{"status": {"code": 0, "message": ""}}`)
+	})
+	defer srv.Close()
+
+	if err := c.CallFunctions(context.Background(), SelectThermostat("123"), ResumeProgram(true)); err != nil {
+		t.Fatalf("CallFunctions(...): unexpected error: %v", err)
+	}
+	want := `{"selection":{"selectionType":"thermostats","selectionMatch":"123"},"functions":[{"type":"resumeProgram","params":{"resumeAll":true}}]}`
+	if got != want {
+		t.Errorf("CallFunctions(...): got body\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestClientUpdateSettings(t *testing.T) {
+	var got thermostatUpdate
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed decoding request: %v", err)
+		}
+		fmt.Fprint(w, `{"status": {"code": 3, "message": "Validation error."}}`)
+	})
+	defer srv.Close()
+
+	err := c.UpdateSettings(context.Background(), SelectThermostat("123"), &SettingsPatch{HVACMode: "cool"})
+	if err == nil {
+		t.Errorf("UpdateSettings(...): want error from API status, got nil")
+	}
+	if got.Thermostat == nil || got.Thermostat.Settings.HVACMode != "cool" {
+		t.Errorf("UpdateSettings(...): unexpected request %+v", got)
+	}
+}
+
+func TestAcknowledgeParamsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		p       *AcknowledgeParams
+		wantErr bool
+	}{
+		{name: "accept", p: &AcknowledgeParams{ThermostatIdentifier: "123", AckRef: "ref", AckType: AckTypeAccept}},
+		{name: "remind me later", p: &AcknowledgeParams{ThermostatIdentifier: "123", AckRef: "ref", AckType: AckTypeDefer, RemindMeLater: true}},
+		{name: "no ref", p: &AcknowledgeParams{ThermostatIdentifier: "123", AckType: AckTypeAccept}, wantErr: true},
+		{name: "bad type", p: &AcknowledgeParams{ThermostatIdentifier: "123", AckRef: "ref", AckType: "ignore"}, wantErr: true},
+	} {
+		if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+const runtimeReportURL = "/1/runtimeReport"
+
+// Limits imposed by the API on a single runtime report request.
+const (
+	RuntimeReportMaxDays        = 31
+	RuntimeReportMaxThermostats = 25
+)
+
+// ColumnKind describes how values of a runtime report column are parsed.
+type ColumnKind int
+
+// Possible ColumnKinds.
+const (
+	// ColumnString values are kept verbatim.
+	ColumnString ColumnKind = iota
+	// ColumnTemperature values are decimal degrees Fahrenheit.
+	ColumnTemperature
+	// ColumnRuntime values are seconds of equipment runtime in the interval.
+	ColumnRuntime
+	// ColumnPercent values are percentages, such as humidity.
+	ColumnPercent
+	// ColumnNumber values are any other number.
+	ColumnNumber
+)
+
+// RuntimeReportColumns maps each runtime report column to its kind.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/operations/get-runtime-report.shtml
+var RuntimeReportColumns = map[string]ColumnKind{
+	"auxHeat1":          ColumnRuntime,
+	"auxHeat2":          ColumnRuntime,
+	"auxHeat3":          ColumnRuntime,
+	"compCool1":         ColumnRuntime,
+	"compCool2":         ColumnRuntime,
+	"compHeat1":         ColumnRuntime,
+	"compHeat2":         ColumnRuntime,
+	"dehumidifier":      ColumnRuntime,
+	"dmOffset":          ColumnTemperature,
+	"economizer":        ColumnRuntime,
+	"fan":               ColumnRuntime,
+	"humidifier":        ColumnRuntime,
+	"hvacMode":          ColumnString,
+	"outdoorHumidity":   ColumnPercent,
+	"outdoorTemp":       ColumnTemperature,
+	"sky":               ColumnPercent,
+	"ventilator":        ColumnRuntime,
+	"wind":              ColumnNumber,
+	"zoneAveTemp":       ColumnTemperature,
+	"zoneCalendarEvent": ColumnString,
+	"zoneClimate":       ColumnString,
+	"zoneCoolTemp":      ColumnTemperature,
+	"zoneHeatTemp":      ColumnTemperature,
+	"zoneHumidity":      ColumnPercent,
+	"zoneHumidityHigh":  ColumnPercent,
+	"zoneHumidityLow":   ColumnPercent,
+	"zoneHvacMode":      ColumnString,
+	"zoneOccupancy":     ColumnNumber,
+}
+
+// RuntimeReportRequest describes the runtime report to retrieve.
+type RuntimeReportRequest struct {
+	// ThermostatIdentifiers to report on. At most RuntimeReportMaxThermostats.
+	ThermostatIdentifiers []string
+	// StartDate and EndDate of the report, inclusive. Only the UTC date is
+	// used, and they may be at most RuntimeReportMaxDays apart.
+	StartDate, EndDate time.Time
+	// Columns to include in the report. See RuntimeReportColumns.
+	Columns []string
+}
+
+func (r *RuntimeReportRequest) validate() error {
+	if n := len(r.ThermostatIdentifiers); n < 1 || n > RuntimeReportMaxThermostats {
+		return fmt.Errorf("runtime report requires 1 to %d thermostats, got %d", RuntimeReportMaxThermostats, n)
+	}
+	if len(r.Columns) < 1 {
+		return fmt.Errorf("runtime report requires at least one column")
+	}
+	if r.EndDate.Before(r.StartDate) {
+		return fmt.Errorf("runtime report end date %v is before start date %v", r.EndDate, r.StartDate)
+	}
+	if r.EndDate.Sub(r.StartDate) >= RuntimeReportMaxDays*24*time.Hour {
+		return fmt.Errorf("runtime report may span at most %d days", RuntimeReportMaxDays)
+	}
+	return nil
+}
+
+// runtimeReportBody is the request format expected by the runtimeReport API.
+type runtimeReportBody struct {
+	Selection     *egobee.Selection `json:"selection"`
+	StartDate     string            `json:"startDate"`
+	StartInterval int               `json:"startInterval"`
+	EndDate       string            `json:"endDate"`
+	EndInterval   int               `json:"endInterval"`
+	Columns       string            `json:"columns"`
+}
+
+type runtimeReportResponse struct {
+	Columns    string `json:"columns"`
+	ReportList []struct {
+		ThermostatIdentifier string   `json:"thermostatIdentifier"`
+		RowCount             int      `json:"rowCount"`
+		RowList              []string `json:"rowList"`
+	} `json:"reportList"`
+	Status status `json:"status"`
+}
+
+// RuntimeReportRow is a single 5 minute interval of a runtime report. Columns
+// without a value in the interval are absent.
+type RuntimeReportRow struct {
+	// Date and Time of the interval, in thermostat local time.
+	Date, Time string
+	// Values of all numeric columns.
+	Values map[string]float64
+	// Strings contains the values of ColumnString columns.
+	Strings map[string]string
+}
+
+// In returns the time of the row interpreted in loc, which should be the
+// location of the thermostat which reported it.
+func (r *RuntimeReportRow) In(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(TimestampFormat, r.Date+" "+r.Time, loc)
+}
+
+// ThermostatRuntimeReport contains the runtime report rows for one Thermostat.
+type ThermostatRuntimeReport struct {
+	ThermostatIdentifier string
+	Rows                 []*RuntimeReportRow
+}
+
+// parseRuntimeReportRow parses a CSV row from the API, which consists of the
+// date, time, and then a value for each of columns.
+func parseRuntimeReportRow(columns []string, row string) (*RuntimeReportRow, error) {
+	fields := strings.Split(row, ",")
+	if len(fields) != len(columns)+2 {
+		return nil, fmt.Errorf("row %q has %d fields, want %d", row, len(fields), len(columns)+2)
+	}
+	r := &RuntimeReportRow{
+		Date:    fields[0],
+		Time:    fields[1],
+		Values:  make(map[string]float64),
+		Strings: make(map[string]string),
+	}
+	for i, column := range columns {
+		v := fields[i+2]
+		if v == "" {
+			continue
+		}
+		kind, ok := RuntimeReportColumns[column]
+		if !ok || kind == ColumnString {
+			r.Strings[column] = v
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %q value %q is not a number: %v", column, v, err)
+		}
+		r.Values[column] = f
+	}
+	return r, nil
+}
+
+// RuntimeReport retrieves historical runtime data in 5 minute intervals.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/operations/get-runtime-report.shtml
+func (c *Client) RuntimeReport(ctx context.Context, rr *RuntimeReportRequest) ([]*ThermostatRuntimeReport, error) {
+	if err := rr.validate(); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(&runtimeReportBody{
+		Selection: &egobee.Selection{
+			SelectionType:  egobee.SelectionTypeThermostats,
+			SelectionMatch: strings.Join(rr.ThermostatIdentifiers, ","),
+		},
+		StartDate:     rr.StartDate.UTC().Format("2006-01-02"),
+		StartInterval: 0,
+		EndDate:       rr.EndDate.UTC().Format("2006-01-02"),
+		EndInterval:   287,
+		Columns:       strings.Join(rr.Columns, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.getRequest(ctx, runtimeReportURL, url.Values{
+		"format": {"json"},
+		"body":   {string(body)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &runtimeReportResponse{}
+	if err := c.doJSON(req, res); err != nil {
+		return nil, err
+	}
+	if err := res.Status.err(); err != nil {
+		return nil, err
+	}
+
+	columns := strings.Split(res.Columns, ",")
+	reports := make([]*ThermostatRuntimeReport, 0, len(res.ReportList))
+	for _, report := range res.ReportList {
+		tr := &ThermostatRuntimeReport{
+			ThermostatIdentifier: report.ThermostatIdentifier,
+			Rows:                 make([]*RuntimeReportRow, 0, len(report.RowList)),
+		}
+		for _, row := range report.RowList {
+			r, err := parseRuntimeReportRow(columns, row)
+			if err != nil {
+				return nil, fmt.Errorf("thermostat %v: %v", report.ThermostatIdentifier, err)
+			}
+			tr.Rows = append(tr.Rows, r)
+		}
+		reports = append(reports, tr)
+	}
+	return reports, nil
+}
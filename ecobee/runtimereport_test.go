@@ -0,0 +1,94 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRuntimeReportRow(t *testing.T) {
+	columns := []string{"zoneAveTemp", "compCool1", "zoneClimate", "outdoorTemp"}
+	got, err := parseRuntimeReportRow(columns, "2020-07-01,12:05:00,72.5,300,Home,")
+	if err != nil {
+		t.Fatalf("parseRuntimeReportRow(...): unexpected error: %v", err)
+	}
+	if got.Date != "2020-07-01" || got.Time != "12:05:00" {
+		t.Errorf("parseRuntimeReportRow(...): got date %q time %q", got.Date, got.Time)
+	}
+	if got.Values["zoneAveTemp"] != 72.5 || got.Values["compCool1"] != 300 {
+		t.Errorf("parseRuntimeReportRow(...): incorrect values %v", got.Values)
+	}
+	if _, ok := got.Values["outdoorTemp"]; ok {
+		t.Errorf("parseRuntimeReportRow(...): empty outdoorTemp should be absent")
+	}
+	if got.Strings["zoneClimate"] != "Home" {
+		t.Errorf("parseRuntimeReportRow(...): incorrect strings %v", got.Strings)
+	}
+
+	for _, row := range []string{
+		"2020-07-01,12:05:00,72.5",
+		"2020-07-01,12:05:00,warm,300,Home,",
+	} {
+		if _, err := parseRuntimeReportRow(columns, row); err == nil {
+			t.Errorf("parseRuntimeReportRow(%q): want error, got nil", row)
+		}
+	}
+}
+
+func TestClientRuntimeReport(t *testing.T) {
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != runtimeReportURL {
+			http.NotFound(w, r)
+			return
+		}
+		body := &runtimeReportBody{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("body")), body); err != nil {
+			t.Errorf("failed decoding request body: %v", err)
+		}
+		if body.StartDate != "2020-07-01" || body.EndDate != "2020-07-02" || body.Selection.SelectionMatch != "123,456" {
+			t.Errorf("incorrect request body: %+v", body)
+		}
+		fmt.Fprintf(w, `{
+		  "columns": %q,
+		  "reportList": [{"thermostatIdentifier": "123", "rowCount": 1, "rowList": ["2020-07-01,00:00:00,71.2,60"]}],
+		  "status": {"code": 0}
+		}`, body.Columns)
+	})
+	defer srv.Close()
+
+	got, err := c.RuntimeReport(context.Background(), &RuntimeReportRequest{
+		ThermostatIdentifiers: []string{"123", "456"},
+		StartDate:             time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:               time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC),
+		Columns:               []string{"zoneAveTemp", "fan"},
+	})
+	if err != nil {
+		t.Fatalf("RuntimeReport(...): unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].ThermostatIdentifier != "123" || len(got[0].Rows) != 1 {
+		t.Fatalf("RuntimeReport(...): unexpected reports %+v", got)
+	}
+	if v := got[0].Rows[0].Values["fan"]; v != 60 {
+		t.Errorf("RuntimeReport(...): got fan %v, want 60", v)
+	}
+}
+
+func TestRuntimeReportRequestValidate(t *testing.T) {
+	day := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name string
+		req  *RuntimeReportRequest
+	}{
+		{name: "no thermostats", req: &RuntimeReportRequest{Columns: []string{"fan"}, StartDate: day, EndDate: day}},
+		{name: "no columns", req: &RuntimeReportRequest{ThermostatIdentifiers: []string{"1"}, StartDate: day, EndDate: day}},
+		{name: "backwards", req: &RuntimeReportRequest{ThermostatIdentifiers: []string{"1"}, Columns: []string{"fan"}, StartDate: day, EndDate: day.Add(-time.Hour)}},
+		{name: "too long", req: &RuntimeReportRequest{ThermostatIdentifiers: []string{"1"}, Columns: []string{"fan"}, StartDate: day, EndDate: day.AddDate(0, 0, RuntimeReportMaxDays)}},
+	} {
+		if err := tt.req.validate(); err == nil {
+			t.Errorf("%v: want error, got nil", tt.name)
+		}
+	}
+}
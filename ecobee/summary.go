@@ -0,0 +1,84 @@
+package ecobee
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// Revision of a Thermostat, as reported in the ThermostatSummary RevisionList.
+// Each revision changes whenever the corresponding portion of the Thermostat
+// changes, so clients need only fetch the portions with new revisions.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/operations/get-thermostat-summary.shtml
+type Revision struct {
+	Identifier string
+	Name       string
+	Connected  bool
+	// ThermostatRev changes with the thermostat program, hvac mode, settings or
+	// configuration.
+	ThermostatRev string
+	// AlertsRev changes whenever alerts are added or removed.
+	AlertsRev string
+	// RuntimeRev changes whenever the thermostat sends a runtime update, which
+	// includes equipment state and sensor readings.
+	RuntimeRev string
+	// IntervalRev changes whenever the thermostat sends a new 15 minute batch of
+	// extended runtime intervals.
+	IntervalRev string
+}
+
+const thermostatSummaryURL = "/1/thermostatSummary"
+
+// ThermostatSummary of the Thermostats matching selection, including their
+// revisions and running equipment. Only the type and match of selection are
+// used; if it is nil, all registered Thermostats are matched. It replaces
+// egobee.Client.ThermostatSummary, so that the request is cancelled along with
+// ctx.
+func (c *Client) ThermostatSummary(ctx context.Context, selection *egobee.Selection) (*egobee.ThermostatSummary, error) {
+	sel := &egobee.Selection{
+		SelectionType:          egobee.SelectionTypeRegistered,
+		IncludeEquipmentStatus: true,
+	}
+	if selection != nil {
+		sel.SelectionType = selection.SelectionType
+		sel.SelectionMatch = selection.SelectionMatch
+	}
+	req, err := c.selectionRequest(ctx, thermostatSummaryURL, sel, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &egobee.ThermostatSummary{}
+	if err := c.doJSON(req, s); err != nil {
+		return nil, err
+	}
+	if s.Status.Code != 0 {
+		return nil, (&status{Code: s.Status.Code, Message: s.Status.Message}).err()
+	}
+	return s, nil
+}
+
+// Revisions parses the RevisionList of a ThermostatSummary. The name of a
+// thermostat is chosen by its owner, and may itself contain colons, so it is
+// whatever lies between the identifier and the last five fields.
+func Revisions(s *egobee.ThermostatSummary) ([]Revision, error) {
+	revs := make([]Revision, 0, len(s.RevisionList))
+	for _, r := range s.RevisionList {
+		f := strings.Split(r, ":")
+		if len(f) < 7 {
+			return nil, fmt.Errorf("thermostat revision %q does not have seven fields", r)
+		}
+		n := len(f) - 5
+		revs = append(revs, Revision{
+			Identifier:    f[0],
+			Name:          strings.Join(f[1:n], ":"),
+			Connected:     f[n] == "true",
+			ThermostatRev: f[n+1],
+			AlertsRev:     f[n+2],
+			RuntimeRev:    f[n+3],
+			IntervalRev:   f[n+4],
+		})
+	}
+	return revs, nil
+}
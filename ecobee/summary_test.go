@@ -0,0 +1,93 @@
+package ecobee
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestRevisions(t *testing.T) {
+	got, err := Revisions(&egobee.ThermostatSummary{
+		RevisionList: []string{"123456789:Home:true:t1:a1:r1:i1"},
+	})
+	if err != nil {
+		t.Fatalf("Revisions(...): unexpected error: %v", err)
+	}
+	want := Revision{
+		Identifier:    "123456789",
+		Name:          "Home",
+		Connected:     true,
+		ThermostatRev: "t1",
+		AlertsRev:     "a1",
+		RuntimeRev:    "r1",
+		IntervalRev:   "i1",
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Revisions(...): got %+v, want [%+v]", got, want)
+	}
+
+	// Names may contain colons.
+	got, err = Revisions(&egobee.ThermostatSummary{
+		RevisionList: []string{"123456789:Den: upstairs:false:t2:a2:r2:i2"},
+	})
+	if err != nil {
+		t.Fatalf("Revisions(...) with colon in name: unexpected error: %v", err)
+	}
+	want = Revision{
+		Identifier:    "123456789",
+		Name:          "Den: upstairs",
+		ThermostatRev: "t2",
+		AlertsRev:     "a2",
+		RuntimeRev:    "r2",
+		IntervalRev:   "i2",
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Revisions(...) with colon in name: got %+v, want [%+v]", got, want)
+	}
+
+	if _, err := Revisions(&egobee.ThermostatSummary{RevisionList: []string{"123:Home:true"}}); err == nil {
+		t.Errorf("Revisions(...) with short revision: want error, got nil")
+	}
+}
+
+func TestClientThermostatSummary(t *testing.T) {
+	var selections []string
+	c, srv := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != thermostatSummaryURL {
+			t.Errorf("unexpected request for %v", r.URL.Path)
+		}
+		selections = append(selections, r.URL.Query().Get("json"))
+		fmt.Fprint(w, `{"revisionList": ["123:Home:true:t:a:r:i"], "statusList": ["123:fan"], "thermostatCount": 1, "status": {"code": 0}}`)
+	})
+	defer srv.Close()
+	got, err := c.ThermostatSummary(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ThermostatSummary(...): unexpected error: %v", err)
+	}
+	if len(got.RevisionList) != 1 || len(got.StatusList) != 1 {
+		t.Errorf("ThermostatSummary(...): got %+v", got)
+	}
+	if _, err := c.ThermostatSummary(context.Background(), &egobee.Selection{
+		SelectionType:  egobee.SelectionTypeManagementSet,
+		SelectionMatch: "/Toronto",
+		IncludeAlerts:  true,
+	}); err != nil {
+		t.Fatalf("ThermostatSummary(...): unexpected error: %v", err)
+	}
+	want := []string{
+		`{"selection":{"selectionType":"registered","selectionMatch":"","includeEquipmentStatus":true}}`,
+		`{"selection":{"selectionType":"managementSet","selectionMatch":"/Toronto","includeEquipmentStatus":true}}`,
+	}
+	if fmt.Sprint(selections) != fmt.Sprint(want) {
+		t.Errorf("ThermostatSummary(...): got selections %v, want %v", selections, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.ThermostatSummary(ctx, nil); err == nil {
+		t.Errorf("ThermostatSummary(...) with cancelled context: want error, got nil")
+	}
+}
@@ -0,0 +1,152 @@
+package ecobee
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// This file contains types for the ecobee v1 API which are either missing from,
+// or modeled incorrectly by, egobee.
+
+// Thermostat wraps egobee.Thermostat, replacing the fields which egobee cannot
+// decode from the API response.
+type Thermostat struct {
+	egobee.Thermostat
+
+	// ExtendedRuntime shadows egobee.Thermostat.ExtendedRuntime.
+	ExtendedRuntime ExtendedRuntime `json:"extendedRuntime"`
+}
+
+// ExtendedRuntime contains the last three 5 minute interval values sent by the
+// thermostat for the past 15 minutes of runtime. Unlike egobee.ExtendedRuntime,
+// the per-interval fields are arrays of three values, oldest first, as they are
+// in the API response.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/objects/ExtendedRuntime.shtml
+type ExtendedRuntime struct {
+	LastReadingTimestamp     string   `json:"lastReadingTimestamp"`
+	RuntimeDate              string   `json:"runtimeDate"`
+	RuntimeInterval          int      `json:"runtimeInterval"`
+	ActualTemperature        []int    `json:"actualTemperature"`
+	ActualHumidity           []int    `json:"actualHumidity"`
+	DesiredHeat              []int    `json:"desiredHeat"`
+	DesiredCool              []int    `json:"desiredCool"`
+	DesiredHumidity          []int    `json:"desiredHumidity"`
+	DesiredDehumidity        []int    `json:"desiredDehumidity"`
+	DMOffset                 []int    `json:"dmOffset"`
+	HVACMode                 []string `json:"hvacMode"`
+	HeatPump1                []int    `json:"heatPump1"`
+	HeatPump2                []int    `json:"heatPump2"`
+	AuxHeat1                 []int    `json:"auxHeat1"`
+	AuxHeat2                 []int    `json:"auxHeat2"`
+	AuxHeat3                 []int    `json:"auxHeat3"`
+	Cool1                    []int    `json:"cool1"`
+	Cool2                    []int    `json:"cool2"`
+	Fan                      []int    `json:"fan"`
+	Humidifier               []int    `json:"humidifier"`
+	Dehumidifier             []int    `json:"dehumidifier"`
+	Economizer               []int    `json:"economizer"`
+	Ventilator               []int    `json:"ventilator"`
+	CurrentElectricityBill   int      `json:"currentElectricityBill"`
+	ProjectedElectricityBill int      `json:"projectedElectricityBill"`
+}
+
+// TimestampFormat is the format of UTC timestamps in API responses.
+const TimestampFormat = "2006-01-02 15:04:05"
+
+// RuntimeIntervalLength is the length of a single runtime interval.
+const RuntimeIntervalLength = 5 * time.Minute
+
+// RuntimeInterval is the runtime of HVAC equipment during a single 5 minute
+// interval.
+type RuntimeInterval struct {
+	// Time at which the interval was read.
+	Time time.Time
+	// Runtime in seconds of each piece of equipment, keyed by the same names the
+	// API uses in equipmentStatus.
+	Runtime map[string]int
+}
+
+// Intervals returns the equipment runtime for each interval in the
+// ExtendedRuntime, oldest first.
+func (r *ExtendedRuntime) Intervals() ([]RuntimeInterval, error) {
+	last, err := time.Parse(TimestampFormat, r.LastReadingTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lastReadingTimestamp %q: %v", r.LastReadingTimestamp, err)
+	}
+	// Equipment names match those reported in the thermostat summary
+	// equipmentStatus, so that runtime can be joined with hvac_in_operation.
+	equipment := map[string][]int{
+		"heatPump":     r.HeatPump1,
+		"heatPump2":    r.HeatPump2,
+		"auxHeat1":     r.AuxHeat1,
+		"auxHeat2":     r.AuxHeat2,
+		"auxHeat3":     r.AuxHeat3,
+		"compCool1":    r.Cool1,
+		"compCool2":    r.Cool2,
+		"fan":          r.Fan,
+		"humidifier":   r.Humidifier,
+		"dehumidifier": r.Dehumidifier,
+		"economizer":   r.Economizer,
+		"ventilator":   r.Ventilator,
+	}
+	const n = 3
+	intervals := make([]RuntimeInterval, n)
+	for i := range intervals {
+		intervals[i] = RuntimeInterval{
+			Time:    last.Add(-time.Duration(n-1-i) * RuntimeIntervalLength),
+			Runtime: make(map[string]int),
+		}
+	}
+	for name, values := range equipment {
+		if len(values) != n {
+			continue
+		}
+		for i, v := range values {
+			intervals[i].Runtime[name] = v
+		}
+	}
+	return intervals, nil
+}
+
+// weatherSymbolNames are keyed by the values used in the API. The egobee
+// WeatherSymbol constants are offset by one from these, so are not used.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/objects/WeatherForecast.shtml
+var weatherSymbolNames = map[egobee.WeatherSymbol]string{
+	-2: "no_symbol",
+	0:  "sunny",
+	1:  "few_clouds",
+	2:  "partly_cloudy",
+	3:  "mostly_cloudy",
+	4:  "overcast",
+	5:  "drizzle",
+	6:  "rain",
+	7:  "freezing_rain",
+	8:  "showers",
+	9:  "hail",
+	10: "snow",
+	11: "flurries",
+	12: "freezing_snow",
+	13: "blizzard",
+	14: "pellets",
+	15: "thunderstorm",
+	16: "windy",
+	17: "tornado",
+	18: "fog",
+	19: "haze",
+	20: "smoke",
+	21: "dust",
+}
+
+// WeatherSymbolName returns the name of a WeatherSymbol, or "unknown".
+func WeatherSymbolName(s egobee.WeatherSymbol) string {
+	if name, ok := weatherSymbolNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// WeatherUnknown is the value the API uses in WeatherForecast fields for which
+// there is no data.
+const WeatherUnknown = -5002
@@ -0,0 +1,52 @@
+package ecobee
+
+import (
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestWeatherSymbolName(t *testing.T) {
+	for sym, want := range map[egobee.WeatherSymbol]string{
+		-2: "no_symbol",
+		0:  "sunny",
+		21: "dust",
+		99: "unknown",
+	} {
+		if got := WeatherSymbolName(sym); got != want {
+			t.Errorf("WeatherSymbolName(%d): got %q, want %q", sym, got, want)
+		}
+	}
+}
+
+func TestExtendedRuntimeIntervals(t *testing.T) {
+	r := &ExtendedRuntime{
+		LastReadingTimestamp: "2020-07-01 12:10:00",
+		Cool1:                []int{0, 300, 210},
+		Fan:                  []int{0, 300, 240},
+		HeatPump1:            []int{1, 2}, // Malformed, so ignored.
+	}
+	got, err := r.Intervals()
+	if err != nil {
+		t.Fatalf("Intervals(): unexpected error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Intervals(): got %d intervals, want 3", len(got))
+	}
+	wantTimes := []string{"2020-07-01 12:00:00", "2020-07-01 12:05:00", "2020-07-01 12:10:00"}
+	for i, want := range wantTimes {
+		if gotTime := got[i].Time.Format(TimestampFormat); gotTime != want {
+			t.Errorf("interval %d: got time %v, want %v", i, gotTime, want)
+		}
+	}
+	if got[2].Runtime["compCool1"] != 210 || got[2].Runtime["fan"] != 240 {
+		t.Errorf("interval 2: incorrect runtime %v", got[2].Runtime)
+	}
+	if _, ok := got[0].Runtime["heatPump"]; ok {
+		t.Errorf("interval 0: malformed heatPump1 should not be reported")
+	}
+
+	if _, err := (&ExtendedRuntime{}).Intervals(); err == nil {
+		t.Errorf("Intervals() with no timestamp: want error, got nil")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/cfunkhouser/egobee"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// replayHosts are the local fakes of the ecobee API which serve each directory
+// of fixtures, by directory. They are shared by every client replaying the same
+// directory, so that an account which is restarted by a reload carries on where
+// it left off.
+var replayHosts = struct {
+	sync.Mutex
+	hosts map[string]string
+}{hosts: make(map[string]string)}
+
+// replayHost starts serving the fixtures in dir, if it is not already served,
+// and returns the API host serving them.
+func replayHost(dir string) (string, error) {
+	replayHosts.Lock()
+	defer replayHosts.Unlock()
+	if host, ok := replayHosts.hosts[dir]; ok {
+		return host, nil
+	}
+	r, err := ecobee.NewReplayer(dir)
+	if err != nil {
+		return "", err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(l, r)
+	host := "http://" + l.Addr().String()
+	log.Printf("Replaying fixtures in %v on %v", dir, host)
+	replayHosts.hosts[dir] = host
+	return host, nil
+}
+
+// checkFixtureFlags returns an error if both --record and --replay are set.
+func checkFixtureFlags(c *cli.Context) error {
+	if c.String("record") != "" && c.String("replay") != "" {
+		return cli.Exit("--record and --replay cannot be used together", 1)
+	}
+	return nil
+}
+
+// replayClient creates an ecobee API client for the account named account,
+// which is served the fixtures recorded for it in dir. It is granted tokens by
+// the fake, so no token store is used.
+func replayClient(dir, account string, opts *egobee.Options) (*ecobee.Client, error) {
+	host, err := replayHost(filepath.Join(dir, account))
+	if err != nil {
+		return nil, cli.Exit(fmt.Errorf("failed replaying %q: %v", dir, err), 1)
+	}
+	o := *opts
+	o.APIHost = host
+	return ecobee.New("replay", egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{}), &o), nil
+}
+
+// record the responses to client for the account named account in a
+// subdirectory of dir, or dir itself if the account is unnamed.
+func record(client *ecobee.Client, dir, account string) error {
+	rec, err := ecobee.NewRecorder(filepath.Join(dir, account))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("failed recording to %q: %v", dir, err), 1)
+	}
+	client.WrapTransport(rec.Transport)
+	return nil
+}
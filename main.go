@@ -1,36 +1,33 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	cli "github.com/urfave/cli/v2"
 
+	"github.com/cfunkhouser/promobee/config"
+	"github.com/cfunkhouser/promobee/ecobee"
 	"github.com/cfunkhouser/promobee/promobee"
+	"github.com/cfunkhouser/promobee/tokenstore"
 )
 
 const (
-	authURLTemplate = `https://api.ecobee.com/authorize?response_type=ecobeePin&scope=smartWrite&client_id=%v`
-	tokenURL        = "https://api.ecobee.com/token"
+	backfillDateLayout = "2006-01-02"
 )
 
-var (
-	// The Ecobee API docs recommend polling no more frequently than 3 minutes.
-	pollInterval = time.Minute * 3
-)
-
-func init() {
-	flag.DurationVar(&pollInterval, "poll_interval", time.Minute*3, "Interval at which to poll the Ecobee API for updates.")
-}
-
 func main() {
 	app := &cli.App{
 		Name:        "promobee",
@@ -50,9 +47,20 @@ func main() {
 			&cli.StringFlag{
 				Name:    "store",
 				Aliases: []string{"s"},
-				Usage:   "Ecobee API credential token store file location. Required.",
+				Usage:   "Ecobee API credential token store: a file path, or a file://, encfile:// or http-kv:// URI. Required.",
 				EnvVars: []string{"PROMOBEE_TOKEN_STORE"},
 			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "If set, the configuration file, in a subset of TOML described in the README, which is reloaded on SIGHUP or when it changes.",
+				EnvVars: []string{"PROMOBEE_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "api_host",
+				Usage:   "Ecobee API host, for testing against another implementation of the API.",
+				Value:   "https://api.ecobee.com",
+				EnvVars: []string{"PROMOBEE_API_HOST"},
+			},
 			&cli.Uint64Flag{
 				Name:    "port",
 				Aliases: []string{"p"},
@@ -66,19 +74,109 @@ func main() {
 				Usage:   "Address to bind for serving Prometheus metrics",
 				EnvVars: []string{"PROMOBEE_ADDRESS"},
 			},
+			&cli.StringFlag{
+				Name:    "control_token",
+				Usage:   "If set, enables the /thermostat/{id}/ control endpoints for requests bearing this token.",
+				EnvVars: []string{"PROMOBEE_CONTROL_TOKEN"},
+			},
 			&cli.StringFlag{
 				Name:    "httplog",
 				Usage:   "If set to a file path, all HTTP requests and responses will be logged there.",
 				EnvVars: []string{"PROMOBEE_HTTP_LOG"},
 			},
+			&cli.StringFlag{
+				Name:    "record",
+				Usage:   "If set to a directory, every ecobee API response is saved there as a fixture, with credentials redacted. The fixtures of each account of --config are saved in a subdirectory named for it.",
+				EnvVars: []string{"PROMOBEE_RECORD"},
+			},
+			&cli.StringFlag{
+				Name:    "replay",
+				Usage:   "If set to a directory recorded with --record, its fixtures are served by a local fake of the ecobee API, which is polled instead. --api_key and --store are not needed.",
+				EnvVars: []string{"PROMOBEE_REPLAY"},
+			},
+			&cli.StringFlag{
+				Name:    "unit",
+				Usage:   "Unit of exported temperatures: fahrenheit, celsius, or auto to follow each thermostat's display setting.",
+				Value:   string(promobee.UnitFahrenheit),
+				EnvVars: []string{"PROMOBEE_UNIT"},
+			},
+			&cli.BoolFlag{
+				Name:    "collector",
+				Usage:   "If set, all thermostat metrics are also exported on /metrics, labeled with thermostat_id and thermostat_name.",
+				EnvVars: []string{"PROMOBEE_COLLECTOR"},
+			},
+			&cli.DurationFlag{
+				Name:    "poll_interval",
+				Usage:   "Interval at which to poll the Ecobee API for updates.",
+				Value:   3 * time.Minute,
+				EnvVars: []string{"PROMOBEE_POLL_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown_timeout",
+				Usage: "Time to wait for in-flight requests to finish when shutting down.",
+				Value: 10 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:  "ready_stale_polls",
+				Usage: "Number of poll intervals without a successful poll after which /readyz fails.",
+				Value: 3,
+			},
+			&cli.StringSliceFlag{
+				Name:  "thermostat_unit",
+				Usage: "Overrides --unit for a single thermostat, as identifier=unit. May be repeated.",
+			},
 		},
 		Action: doServeMetrics,
 		Commands: []*cli.Command{
 			{
-				Name:        "register",
-				Usage:       "Register Promobee application with Ecobee account",
-				Description: "Registers Promobee application with Ecobee account",
-				Action:      doRegister,
+				Name:  "register",
+				Usage: "Register Promobee application with Ecobee account",
+				Description: "Registers Promobee application with Ecobee account. Prints a PIN to be " +
+					"added to the account on the Ecobee website, and waits until it has been.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "scope",
+						Usage: "Scope of the registration: smartRead, smartWrite or ems.",
+						Value: string(egobee.ScopeSmartWrite),
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "If set, progress is written to stdout as JSON objects, one per line.",
+					},
+				},
+				Action: doRegister,
+			},
+			{
+				Name:  "backfill",
+				Usage: "Write historical runtime data as OpenMetrics text",
+				Description: "Retrieves historical runtime data for all registered thermostats from the " +
+					"Ecobee runtime report API, and writes it as OpenMetrics text suitable for " +
+					"`promtool tsdb create-blocks-from openmetrics`. Series are named and labeled " +
+					"as they are exported live with the same --unit, --thermostat_unit and --collector.",
+				Flags: []cli.Flag{
+					&cli.TimestampFlag{
+						Name:     "from",
+						Usage:    "First day to backfill, as YYYY-MM-DD. Required.",
+						Layout:   backfillDateLayout,
+						Required: true,
+					},
+					&cli.TimestampFlag{
+						Name:     "to",
+						Usage:    "Last day to backfill, as YYYY-MM-DD. Required.",
+						Layout:   backfillDateLayout,
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "columns",
+						Usage: fmt.Sprintf("Runtime report columns to export. Defaults to all of %v.", strings.Join(promobee.BackfillColumns(), ",")),
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "File to which OpenMetrics text is written. Defaults to stdout.",
+					},
+				},
+				Action: doBackfill,
 			},
 		},
 	}
@@ -88,43 +186,232 @@ func main() {
 	}
 }
 
-func doServeMetrics(c *cli.Context) error {
-	hostPort := fmt.Sprintf("%v:%d", c.String("address"), c.Uint64("port"))
-
-	opts := &egobee.Options{}
+// clientOptions from the global flags.
+func clientOptions(c *cli.Context) (*egobee.Options, error) {
+	if err := checkFixtureFlags(c); err != nil {
+		return nil, err
+	}
+	opts := &egobee.Options{APIHost: c.String("api_host")}
 	if httpLog := c.String("httplog"); httpLog != "" {
 		f, err := os.OpenFile(httpLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			return cli.Exit(fmt.Errorf("failed creating http log %q: %v", httpLog, err), 1)
+			return nil, cli.Exit(fmt.Errorf("failed creating http log %q: %v", httpLog, err), 1)
 		}
 		opts.Log = true
 		opts.LogTo = f
 	}
+	return opts, nil
+}
+
+// openClient creates an ecobee API client for the account named account, using
+// apiKey and the token store at storePath, unless the account is replayed.
+func openClient(c *cli.Context, account, apiKey, storePath string, opts *egobee.Options) (*ecobee.Client, error) {
+	if dir := c.String("replay"); dir != "" {
+		return replayClient(dir, account, opts)
+	}
+	ts, err := tokenstore.Open(storePath)
+	if err != nil {
+		return nil, cli.Exit(fmt.Errorf("failed initializing store %q: %v", storePath, err), 1)
+	}
+	client := ecobee.New(apiKey, ts, opts)
+	if dir := c.String("record"); dir != "" {
+		if err := record(client, dir, account); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
 
-	storePath := c.String("store")
-	if storePath == "" {
+// newClient creates an ecobee API client from the global flags.
+func newClient(c *cli.Context) (*ecobee.Client, error) {
+	opts, err := clientOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	storePath, apiKey := c.String("store"), c.String("api_key")
+	if c.String("replay") == "" && (storePath == "" || apiKey == "") {
 		cli.ShowAppHelpAndExit(c, 1)
 	}
-	ts, err := egobee.NewPersistentTokenFromDisk(storePath)
+	return openClient(c, "", apiKey, storePath, opts)
+}
+
+// exporter is implemented by both promobee.Accumulator and promobee.Accounts.
+type exporter interface {
+	ServeHealthz(http.ResponseWriter, *http.Request)
+	ServeReadyz(http.ResponseWriter, *http.Request)
+	ServeThermostatsList(http.ResponseWriter, *http.Request)
+	ServeThermostat(http.ResponseWriter, *http.Request)
+	ServeSchedule(http.ResponseWriter, *http.Request)
+}
+
+// serve HTTP on addr until the server is shut down. Errors other than
+// http.ErrServerClosed are sent to errs.
+func serve(addr string, errs chan<- error) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: addr}
+	go func() {
+		log.Printf("Starting on %v", addr)
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+	return srv, nil
+}
+
+// unitOpts are the Opts setting the units of exported temperatures, which are
+// shared by the exporter and backfill.
+func unitOpts(c *cli.Context) (promobee.Opts, error) {
+	unit, err := promobee.ParseUnit(c.String("unit"))
+	if err != nil {
+		return promobee.Opts{}, err
+	}
+	thermostatUnits, err := promobee.ParseThermostatUnits(c.StringSlice("thermostat_unit"))
 	if err != nil {
-		return cli.Exit(fmt.Errorf("failed initializing store %q: %v", storePath, err), 1)
+		return promobee.Opts{}, err
 	}
+	return promobee.Opts{Unit: unit, ThermostatUnits: thermostatUnits}, nil
+}
 
-	apiKey := c.String("api_key")
-	if apiKey == "" {
-		cli.ShowAppHelpAndExit(c, 1)
+func doServeMetrics(c *cli.Context) error {
+	hostPort := fmt.Sprintf("%v:%d", c.String("address"), c.Uint64("port"))
+
+	opts, err := unitOpts(c)
+	if err != nil {
+		return err
+	}
+	opts.PollInterval = c.Duration("poll_interval")
+	opts.StalePolls = c.Int("ready_stale_polls")
+
+	var p exporter
+	var stop func() error
+	var cfg *configured
+	var collectors []prometheus.Collector
+	var controller http.Handler
+	token := c.String("control_token")
+	if path := c.String("config"); path != "" {
+		cfg, err = startConfigured(c, path, opts)
+		if err != nil {
+			return err
+		}
+		p, stop = cfg.accounts, cfg.Stop
+		collectors = []prometheus.Collector{cfg.accounts.Collector()}
+		controller = cfg.accounts.Controller(token)
+	} else {
+		client, err := newClient(c)
+		if err != nil {
+			return err
+		}
+		client.WrapTransport(promobee.InstrumentTransport)
+		a := promobee.New(c.Context, client, &opts)
+		p = a
+		stop = func() error {
+			a.Stop()
+			if err := client.FlushTokenStore(); err != nil {
+				return fmt.Errorf("failed flushing token store: %v", err)
+			}
+			return nil
+		}
+		collectors = []prometheus.Collector{a.Collector()}
+		controller = promobee.NewController(a, token)
+	}
+
+	if c.Bool("collector") {
+		prometheus.MustRegister(collectors...)
 	}
-	p := promobee.New(egobee.New(apiKey, ts, opts), nil)
 
 	// Export the default metrics.
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", p.ServeHealthz)
+	http.HandleFunc("/readyz", p.ServeReadyz)
 
 	// Export Ecobee metrics
 	http.HandleFunc("/thermostats", p.ServeThermostatsList)
 	http.HandleFunc("/thermostat", p.ServeThermostat)
+	http.HandleFunc("/thermostat/", func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/schedule") {
+			p.ServeSchedule(w, req)
+			return
+		}
+		// The control endpoints are only enabled by a token.
+		if token == "" {
+			http.NotFound(w, req)
+			return
+		}
+		controller.ServeHTTP(w, req)
+	})
+
+	addr := hostPort
+	if cfg != nil {
+		addr = cfg.ListenAddress(hostPort)
+	}
+	errs := make(chan error, 1)
+	srv, err := serve(addr, errs)
+	if err != nil {
+		stop()
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	reload := make(chan struct{}, 1)
+	if cfg != nil {
+		signal.Notify(sig, syscall.SIGHUP)
+		ctx, cancel := context.WithCancel(c.Context)
+		defer cancel()
+		go config.Watch(ctx, cfg.path, configWatchInterval, func() { reload <- struct{}{} })
+	}
+
+	shutdown := func(srv *http.Server) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Duration("shutdown_timeout"))
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+	}
+	for {
+		select {
+		case err := <-errs:
+			stop()
+			return err
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Printf("Received %v, reloading config", s)
+				cfg.Reload()
+				break
+			}
+			log.Printf("Received %v, shutting down", s)
+			// Finish in-flight scrapes before cancelling any API request in
+			// progress, and wait for the poller so that it does not exit in the
+			// middle of a token refresh.
+			shutdown(srv)
+			return stop()
+		case <-reload:
+			cfg.Reload()
+		}
+		if addr := cfg.ListenAddress(hostPort); addr != srv.Addr {
+			next, err := serve(addr, errs)
+			if err != nil {
+				log.Printf("Error listening on %v, still serving on %v: %v", addr, srv.Addr, err)
+				continue
+			}
+			go shutdown(srv)
+			srv = next
+		}
+	}
+}
 
-	log.Printf("Starting on %v", hostPort)
-	return http.ListenAndServe(hostPort, nil)
+// registerStatus is written by register with --json, so that the PIN may be
+// shown by another program.
+type registerStatus struct {
+	Pin     string     `json:"pin,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Resumed is set if the PIN was requested by an earlier, interrupted run.
+	Resumed bool   `json:"resumed,omitempty"`
+	Store   string `json:"store,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 func doRegister(c *cli.Context) error {
@@ -136,40 +423,123 @@ func doRegister(c *cli.Context) error {
 	if apiKey == "" {
 		cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 	}
-
-	resp, err := http.Get(fmt.Sprintf(authURLTemplate, apiKey))
-	if err != nil {
-		return cli.Exit(fmt.Errorf("failed initializing Pin Authentication: %v", err), 1)
+	if err := register(c, storePath, apiKey); err != nil {
+		if c.Bool("json") {
+			json.NewEncoder(os.Stdout).Encode(&registerStatus{Error: err.Error()})
+		}
+		return cli.Exit(err, 1)
 	}
+	return nil
+}
 
-	pac := &egobee.PinAuthenticationChallenge{}
-	if err := json.NewDecoder(resp.Body).Decode(pac); err != nil {
-		return cli.Exit(fmt.Errorf("failed to read Pin Authentication: %v", err), 1)
+func register(c *cli.Context, storePath, apiKey string) error {
+	asJSON := c.Bool("json")
+	scope := egobee.Scope(c.String("scope"))
+	switch scope {
+	case egobee.ScopeSmartRead, egobee.ScopeSmartWrite, egobee.ScopeEMSWrite:
+	default:
+		return fmt.Errorf("invalid scope %q; must be one of smartRead, smartWrite or ems", scope)
 	}
-	resp.Body.Close()
-
-	fmt.Printf("Register with this PIN: %v\n", pac.Pin)
-	fmt.Println("Press any key to continue when done.")
+	ctx, stop := context.WithCancel(c.Context)
+	defer stop()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
 
-	var input string
-	fmt.Scanf("%s", &input)
+	api := c.String("api_host")
+	// The PIN is kept alongside the store until it has been added, so that an
+	// interrupted run may be resumed with the same PIN.
+	pending, err := tokenstore.OpenPending(storePath)
+	if err != nil {
+		return fmt.Errorf("invalid token store: %v", err)
+	}
+	var pin *ecobee.PinChallenge
+	if pending != nil {
+		if pin, err = ecobee.ResumePin(pending, api, apiKey, scope); err != nil {
+			log.Printf("Not resuming registration: %v", err)
+		}
+	}
+	resumed := pin != nil
+	if !resumed {
+		if pin, err = ecobee.RequestPin(ctx, api, apiKey, scope); err != nil {
+			return fmt.Errorf("failed initializing Pin Authentication: %v", err)
+		}
+		if pending != nil {
+			if err := ecobee.SavePin(pending, api, apiKey, pin); err != nil {
+				log.Printf("Failed saving PIN, so registration cannot be resumed if interrupted: %v", err)
+			}
+		}
+	}
+	expires := pin.Expires()
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(&registerStatus{Pin: pin.Pin, Expires: &expires, Resumed: resumed})
+	} else {
+		if resumed {
+			fmt.Printf("Resuming registration with this PIN: %v\n", pin.Pin)
+		} else {
+			fmt.Printf("Register with this PIN: %v\n", pin.Pin)
+		}
+		fmt.Printf("Waiting until it has been added, which must be by %v.\n", expires.Format(time.Kitchen))
+	}
 
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "grant_type=ecobeePin&code=%v&client_id=%v", pac.AuthorizationCode, apiKey)
+	trr, err := ecobee.AwaitPin(ctx, api, apiKey, pin)
+	if err != nil {
+		// Unless interrupted, the PIN can no longer be added.
+		if pending != nil && ctx.Err() == nil {
+			pending.Remove()
+		}
+		return fmt.Errorf("failed authenticating: %v", err)
+	}
+	if _, err = tokenstore.Create(storePath, trr); err != nil {
+		return fmt.Errorf("failed creating persistent store: %v", err)
+	}
+	if pending != nil {
+		pending.Remove()
+	}
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(&registerStatus{Store: storePath})
+	} else {
+		fmt.Printf("Created persistent store at %v\n", storePath)
+	}
+	return nil
+}
 
-	resp, err = http.Post(tokenURL, "application/x-www-form-urlencoded", &buf)
+func doBackfill(c *cli.Context) error {
+	units, err := unitOpts(c)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(c)
 	if err != nil {
-		return cli.Exit(fmt.Errorf("failed authenticating: %v", err), 1)
+		return err
+	}
+
+	w := os.Stdout
+	if output := c.String("output"); output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("failed creating output %q: %v", output, err), 1)
+		}
+		defer f.Close()
+		w = f
 	}
-	defer resp.Body.Close()
 
-	trr := &egobee.TokenRefreshResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(trr); err != nil {
-		return cli.Exit(fmt.Errorf("failed decoding authentication response: %v", err), 1)
+	opts := &promobee.BackfillOpts{
+		From:      *c.Timestamp("from"),
+		To:        *c.Timestamp("to"),
+		Columns:   c.StringSlice("columns"),
+		Opts:      &units,
+		Collector: c.Bool("collector"),
 	}
-	if _, err = egobee.NewPersistentTokenStore(trr, storePath); err != nil {
-		return cli.Exit(fmt.Errorf("failed creating persistent store: %v", err), 1)
+	if err := promobee.Backfill(c.Context, client, opts, w); err != nil {
+		return cli.Exit(fmt.Errorf("failed backfilling: %v", err), 1)
 	}
-	fmt.Printf("Created persistent store at %v\n", storePath)
 	return nil
 }
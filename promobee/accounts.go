@@ -0,0 +1,276 @@
+package promobee
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// accountSeparator separates the account from the identifier of a thermostat.
+const accountSeparator = ":"
+
+// qualify the identifier of a thermostat with its account, unless the account
+// is unnamed.
+func qualify(account, id string) string {
+	if account == "" {
+		return id
+	}
+	return account + accountSeparator + id
+}
+
+var (
+	errNoThermostat        = errors.New("no such thermostat")
+	errAmbiguousThermostat = errors.New("thermostat identifier is not unique; qualify it as account" + accountSeparator + "id")
+)
+
+// Accounts serves the thermostats of several Accumulators, each polling a
+// different ecobee account, as if they were one. Thermostat identifiers are
+// qualified with the account as account:id, but unqualified identifiers are
+// accepted as long as they are unique among the accounts.
+type Accounts struct {
+	mu           sync.RWMutex // protects following members, which are replaced rather than modified
+	names        []string     // sorted
+	accumulators map[string]*Accumulator
+}
+
+// NewAccounts serving accumulators, which must have distinct Opts.Account.
+func NewAccounts(accumulators ...*Accumulator) (*Accounts, error) {
+	as := &Accounts{}
+	for _, a := range accumulators {
+		if err := as.Add(a); err != nil {
+			return nil, err
+		}
+	}
+	return as, nil
+}
+
+// list the names of the accounts, sorted, and their Accumulators.
+func (as *Accounts) list() ([]string, map[string]*Accumulator) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	return as.names, as.accumulators
+}
+
+// replace the accounts with accumulators.
+func (as *Accounts) replace(accumulators map[string]*Accumulator) {
+	names := make([]string, 0, len(accumulators))
+	for name := range accumulators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	as.names, as.accumulators = names, accumulators
+}
+
+// Add a, which is served alongside the Accumulators of other accounts.
+func (as *Accounts) Add(a *Accumulator) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	name := a.options().account()
+	if _, ok := as.accumulators[name]; ok {
+		return fmt.Errorf("account %q is polled more than once", name)
+	}
+	accumulators := map[string]*Accumulator{name: a}
+	for n, other := range as.accumulators {
+		accumulators[n] = other
+	}
+	as.replace(accumulators)
+	return nil
+}
+
+// Remove the account named name, returning its Accumulator, if any. The
+// Accumulator is not stopped.
+func (as *Accounts) Remove(name string) *Accumulator {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	a, ok := as.accumulators[name]
+	if !ok {
+		return nil
+	}
+	accumulators := make(map[string]*Accumulator)
+	for n, other := range as.accumulators {
+		if n != name {
+			accumulators[n] = other
+		}
+	}
+	as.replace(accumulators)
+	return a
+}
+
+// find the Accumulator exporting the thermostat identified by qid, and the
+// unqualified identifier of the thermostat.
+func (as *Accounts) find(qid string) (*Accumulator, string, error) {
+	names, accumulators := as.list()
+	if parts := strings.SplitN(qid, accountSeparator, 2); len(parts) == 2 {
+		a, ok := accumulators[parts[0]]
+		if !ok {
+			return nil, "", errNoThermostat
+		}
+		if _, ok := a.current().thermostats[parts[1]]; !ok {
+			return nil, "", errNoThermostat
+		}
+		return a, parts[1], nil
+	}
+	var found *Accumulator
+	for _, name := range names {
+		a := accumulators[name]
+		if _, ok := a.current().thermostats[qid]; !ok {
+			continue
+		}
+		if found != nil {
+			return nil, "", errAmbiguousThermostat
+		}
+		found = a
+	}
+	if found == nil {
+		return nil, "", errNoThermostat
+	}
+	return found, qid, nil
+}
+
+func findStatus(err error) int {
+	if err == errAmbiguousThermostat {
+		return http.StatusConflict
+	}
+	return http.StatusNotFound
+}
+
+// ServeThermostatsList is a http.HandlerFunc which serves the qualified
+// identifiers of the thermostats of every account.
+func (as *Accounts) ServeThermostatsList(w http.ResponseWriter, _ *http.Request) {
+	names, accumulators := as.list()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	for _, name := range names {
+		for _, id := range accumulators[name].current().ids() {
+			fmt.Fprintf(w, "%v\n", qualify(name, id))
+		}
+	}
+}
+
+// ServeThermostat is a http.HandlerFunc which serves the metrics of the
+// thermostat identified by the id query parameter.
+func (as *Accounts) ServeThermostat(w http.ResponseWriter, req *http.Request) {
+	a, id, err := as.find(req.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), findStatus(err))
+		return
+	}
+	t, ok := a.current().thermostats[id]
+	if !ok {
+		// The thermostat was dropped by a poll since it was found.
+		http.Error(w, errNoThermostat.Error(), http.StatusNotFound)
+		return
+	}
+	t.serve(w, req)
+}
+
+// ServeSchedule is a http.HandlerFunc which serves the program schedule of the
+// thermostat identified as it is by ServeThermostat, in a path of the form
+// /thermostat/{id}/schedule.
+func (as *Accounts) ServeSchedule(w http.ResponseWriter, req *http.Request) {
+	qid, ok := parseSchedulePath(w, req)
+	if !ok {
+		return
+	}
+	a, id, err := as.find(qid)
+	if err != nil {
+		http.Error(w, err.Error(), findStatus(err))
+		return
+	}
+	t, ok := a.current().thermostats[id]
+	if !ok {
+		http.Error(w, errNoThermostat.Error(), http.StatusNotFound)
+		return
+	}
+	t.serveSchedule(w)
+}
+
+// ServeHealthz is a http.HandlerFunc which reports that the process is alive.
+func (as *Accounts) ServeHealthz(w http.ResponseWriter, _ *http.Request) {
+	serveJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// accountsReadiness is the body of /readyz for Accounts.
+type accountsReadiness struct {
+	Ready    bool                  `json:"ready"`
+	Accounts map[string]*readiness `json:"accounts"`
+}
+
+// ServeReadyz is a http.HandlerFunc which reports the readiness of each account,
+// as Accumulator.ServeReadyz does. It fails if any account is not ready, or
+// only the account named by the account query parameter, if present.
+func (as *Accounts) ServeReadyz(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	names, accumulators := as.list()
+	if name := req.URL.Query().Get("account"); name != "" {
+		if _, ok := accumulators[name]; !ok {
+			http.Error(w, "no such account", http.StatusNotFound)
+			return
+		}
+		names = []string{name}
+	}
+	r := &accountsReadiness{Ready: true, Accounts: make(map[string]*readiness)}
+	for _, name := range names {
+		ar := accumulators[name].readiness(now)
+		r.Accounts[name] = ar
+		r.Ready = r.Ready && ar.Ready
+	}
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	serveJSON(w, code, r)
+}
+
+// Controller which controls the thermostats of every account, as NewController
+// does, for requests bearing token. Thermostats are identified as they are by
+// ServeThermostat.
+func (as *Accounts) Controller(token string) *Controller {
+	return &Controller{
+		accumulator: as.find,
+		token:       token,
+	}
+}
+
+// Stop every Accumulator, as Accumulator.Stop does.
+func (as *Accounts) Stop() {
+	_, accumulators := as.list()
+	var wg sync.WaitGroup
+	for _, a := range accumulators {
+		wg.Add(1)
+		go func(a *Accumulator) {
+			defer wg.Done()
+			a.Stop()
+		}(a)
+	}
+	wg.Wait()
+}
+
+// accountsCollector emits the metrics of every thermostat of every account.
+type accountsCollector struct {
+	as *Accounts
+}
+
+// Collector returns a prometheus.Collector which emits the metrics of every
+// thermostat of every account, as Accumulator.Collector does, including those
+// of accounts added later.
+func (as *Accounts) Collector() prometheus.Collector {
+	return &accountsCollector{as: as}
+}
+
+// Describe sends nothing, which makes the Collector unchecked.
+func (*accountsCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *accountsCollector) Collect(ch chan<- prometheus.Metric) {
+	names, accumulators := c.as.list()
+	for _, name := range names {
+		accumulators[name].Collector().Collect(ch)
+	}
+}
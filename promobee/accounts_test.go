@@ -0,0 +1,198 @@
+package promobee
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotAccumulator returns an Accumulator for account which is not polling,
+// whose snapshot contains thermostats identified by ids.
+func snapshotAccumulator(account string, labels map[string]string, ids ...string) *Accumulator {
+	a := &Accumulator{opts: &Opts{Account: account, Labels: labels}}
+	s := &snapshot{thermostats: make(map[string]*thermostatMetrics)}
+	for _, id := range ids {
+		m := newThermostatMetrics(UnitFahrenheit, labels, nil)
+		m.tempMetric.WithLabelValues("Kitchen").Set(70)
+		s.thermostats[id] = m
+	}
+	a.snapshot.Store(s)
+	return a
+}
+
+func TestAccounts(t *testing.T) {
+	as, err := NewAccounts(
+		snapshotAccumulator("office", map[string]string{"site": "office"}, "2", "3"),
+		snapshotAccumulator("home", map[string]string{"site": "home"}, "1", "2"),
+	)
+	if err != nil {
+		t.Fatalf("NewAccounts(...): unexpected error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	as.ServeThermostatsList(rr, httptest.NewRequest(http.MethodGet, "/thermostats", nil))
+	if got, want := rr.Body.String(), "home:1\nhome:2\noffice:2\noffice:3\n"; got != want {
+		t.Errorf("ServeThermostatsList: got %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		id       string
+		wantCode int
+		wantSite string
+	}{
+		{id: "1", wantCode: http.StatusOK, wantSite: "home"},
+		{id: "3", wantCode: http.StatusOK, wantSite: "office"},
+		{id: "office:2", wantCode: http.StatusOK, wantSite: "office"},
+		{id: "2", wantCode: http.StatusConflict},
+		{id: "home:3", wantCode: http.StatusNotFound},
+		{id: "rental:1", wantCode: http.StatusNotFound},
+		{id: "", wantCode: http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		as.ServeThermostat(rr, httptest.NewRequest(http.MethodGet, "/thermostat?id="+tt.id, nil))
+		if rr.Code != tt.wantCode {
+			t.Errorf("ServeThermostat(%q): got status %d, want %d", tt.id, rr.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantSite == "" {
+			continue
+		}
+		if want := `temperature_fahrenheit{location="Kitchen",site="` + tt.wantSite + `"} 70`; !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("ServeThermostat(%q): %q missing from:\n%v", tt.id, want, rr.Body.String())
+		}
+	}
+
+	if _, err := NewAccounts(snapshotAccumulator("home", nil), snapshotAccumulator("home", nil)); err == nil {
+		t.Errorf("NewAccounts(...) with duplicate accounts: want error, got nil")
+	}
+}
+
+func TestAccounts_ServeSchedule(t *testing.T) {
+	home := snapshotAccumulator("home", nil, "1")
+	home.current().thermostats["1"].schedule = newSchedule(testProgram(), UnitFahrenheit)
+	as, err := NewAccounts(home, snapshotAccumulator("office", nil, "1", "2"))
+	if err != nil {
+		t.Fatalf("NewAccounts(...): unexpected error: %v", err)
+	}
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+	}{
+		{path: "/thermostat/home:1/schedule", wantCode: http.StatusOK},
+		{path: "/thermostat/office:1/schedule", wantCode: http.StatusNotFound},
+		{path: "/thermostat/1/schedule", wantCode: http.StatusConflict},
+		{path: "/thermostat/3/schedule", wantCode: http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		as.ServeSchedule(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rr.Code != tt.wantCode {
+			t.Errorf("ServeSchedule(%q): got status %d, want %d", tt.path, rr.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestAccounts_ServeReadyz(t *testing.T) {
+	home := snapshotAccumulator("home", nil)
+	home.health.record(nil, time.Now())
+	office := snapshotAccumulator("office", nil)
+	as, err := NewAccounts(home, office)
+	if err != nil {
+		t.Fatalf("NewAccounts(...): unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		query    string
+		wantCode int
+	}{
+		{query: "", wantCode: http.StatusServiceUnavailable},
+		{query: "?account=home", wantCode: http.StatusOK},
+		{query: "?account=office", wantCode: http.StatusServiceUnavailable},
+		{query: "?account=rental", wantCode: http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		as.ServeReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz"+tt.query, nil))
+		if rr.Code != tt.wantCode {
+			t.Errorf("ServeReadyz(%q): got status %d, want %d; body:\n%v", tt.query, rr.Code, tt.wantCode, rr.Body.String())
+		}
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	for _, tt := range []struct {
+		labels  map[string]string
+		wantErr bool
+	}{
+		{labels: map[string]string{"site": "home"}},
+		{labels: map[string]string{"location": "home"}, wantErr: true},
+		{labels: map[string]string{"thermostat_id": "1"}, wantErr: true},
+		{labels: map[string]string{"not a label": "1"}, wantErr: true},
+	} {
+		if err := CheckLabels(tt.labels); (err != nil) != tt.wantErr {
+			t.Errorf("CheckLabels(%v): got %v, want error: %v", tt.labels, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAccounts_Controller(t *testing.T) {
+	as, err := NewAccounts(snapshotAccumulator("home", nil, "1"), snapshotAccumulator("office", nil, "1"))
+	if err != nil {
+		t.Fatalf("NewAccounts(...): unexpected error: %v", err)
+	}
+	c := as.Controller("secret")
+	for _, tt := range []struct {
+		path, token string
+		wantCode    int
+	}{
+		{path: "/thermostat/1/resume", token: "wrong", wantCode: http.StatusUnauthorized},
+		{path: "/thermostat/1/resume", token: "secret", wantCode: http.StatusConflict},
+		{path: "/thermostat/rental:1/resume", token: "secret", wantCode: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, req)
+		if rr.Code != tt.wantCode {
+			t.Errorf("POST %v: got status %d, want %d", tt.path, rr.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestAccounts_AddRemove(t *testing.T) {
+	as, err := NewAccounts(snapshotAccumulator("home", nil, "1"))
+	if err != nil {
+		t.Fatalf("NewAccounts(...): unexpected error: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(as.Collector())
+
+	office := snapshotAccumulator("office", nil, "2")
+	if err := as.Add(office); err != nil {
+		t.Fatalf("Add(...): unexpected error: %v", err)
+	}
+	if err := as.Add(snapshotAccumulator("office", nil)); err == nil {
+		t.Errorf("Add(...) of existing account: want error, got nil")
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): unexpected error: %v", err)
+	}
+	if got := len(families[0].Metric); families[0].GetName() != "temperature_fahrenheit" || got != 2 {
+		t.Errorf("Gather(): got %d %v series, want 2 temperature_fahrenheit", got, families[0].GetName())
+	}
+
+	if got := as.Remove("office"); got != office {
+		t.Errorf("Remove(%q): got %v, want the office Accumulator", "office", got)
+	}
+	if got := as.Remove("office"); got != nil {
+		t.Errorf("Remove(%q) again: got %v, want nil", "office", got)
+	}
+	rr := httptest.NewRecorder()
+	as.ServeThermostatsList(rr, httptest.NewRequest(http.MethodGet, "/thermostats", nil))
+	if got, want := rr.Body.String(), "home:1\n"; got != want {
+		t.Errorf("ServeThermostatsList: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,361 @@
+package promobee
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// backfillFamily is a metric family written by Backfill. Each is named and
+// labeled as the live metric it fills in for, so that backfilled and live
+// series are one and the same.
+type backfillFamily struct {
+	name string
+	typ  string // OpenMetrics type
+	help string
+	// temperature families are suffixed with the unit, and their help names it.
+	temperature bool
+}
+
+// inUnit returns the family exported for temperatures in unit.
+func (f backfillFamily) inUnit(unit Unit) backfillFamily {
+	if f.temperature {
+		f.name = fmt.Sprintf("%v_%v", f.name, unit)
+		f.help = fmt.Sprintf(f.help, unit.title())
+	}
+	return f
+}
+
+var (
+	actualTemperatureFamily = backfillFamily{
+		name:        "actual_temperature",
+		typ:         "gauge",
+		help:        "Temperature in %v used by an Ecobee thermostat, averaged over its participating sensors.",
+		temperature: true,
+	}
+	actualHumidityFamily = backfillFamily{
+		name: "actual_humidity",
+		typ:  "gauge",
+		help: "Relative humidity as a percentage, as reported by an Ecobee thermostat.",
+	}
+	desiredTemperatureFamily = backfillFamily{
+		name:        "desired_temperature",
+		typ:         "gauge",
+		help:        "Setpoint in %v which an Ecobee thermostat is maintaining, whether from its program or a hold.",
+		temperature: true,
+	}
+	weatherTemperatureFamily = backfillFamily{
+		name:        "weather_temperature",
+		typ:         "gauge",
+		help:        "Outdoor temperature in %v as forecast for an Ecobee thermostat.",
+		temperature: true,
+	}
+	weatherHumidityFamily = backfillFamily{
+		name: "weather_relative_humidity",
+		typ:  "gauge",
+		help: "Outdoor relative humidity as forecast for an Ecobee thermostat.",
+	}
+	equipmentRuntimeFamily = backfillFamily{
+		name: "equipment_runtime_seconds",
+		typ:  "counter",
+		help: "Total seconds HVAC equipment has run, as reported in 5 minute intervals by an Ecobee thermostat.",
+	}
+)
+
+// backfillColumn maps a runtime report column to a metric family, and an
+// optional label which distinguishes it from other columns in that family.
+type backfillColumn struct {
+	family     backfillFamily
+	label, val string
+	// weather columns are labeled as the current conditions at the weather
+	// station of the thermostat.
+	weather bool
+}
+
+func equipmentColumn(equipment string) backfillColumn {
+	return backfillColumn{family: equipmentRuntimeFamily, label: "equipment", val: equipment}
+}
+
+// Equipment names match those used by the live exporter, so backfilled and
+// live series can be queried alike.
+var backfillColumns = map[string]backfillColumn{
+	"zoneAveTemp":     {family: actualTemperatureFamily},
+	"zoneHumidity":    {family: actualHumidityFamily},
+	"zoneHeatTemp":    {family: desiredTemperatureFamily, label: "type", val: "heat"},
+	"zoneCoolTemp":    {family: desiredTemperatureFamily, label: "type", val: "cool"},
+	"outdoorTemp":     {family: weatherTemperatureFamily, weather: true},
+	"outdoorHumidity": {family: weatherHumidityFamily, weather: true},
+	"auxHeat1":        equipmentColumn("auxHeat1"),
+	"auxHeat2":        equipmentColumn("auxHeat2"),
+	"auxHeat3":        equipmentColumn("auxHeat3"),
+	"compCool1":       equipmentColumn("compCool1"),
+	"compCool2":       equipmentColumn("compCool2"),
+	"compHeat1":       equipmentColumn("heatPump"),
+	"compHeat2":       equipmentColumn("heatPump2"),
+	"dehumidifier":    equipmentColumn("dehumidifier"),
+	"economizer":      equipmentColumn("economizer"),
+	"fan":             equipmentColumn("fan"),
+	"humidifier":      equipmentColumn("humidifier"),
+	"ventilator":      equipmentColumn("ventilator"),
+}
+
+// BackfillColumns lists the runtime report columns Backfill can export.
+func BackfillColumns() []string {
+	columns := make([]string, 0, len(backfillColumns))
+	for column := range backfillColumns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// BackfillOpts for Backfill.
+type BackfillOpts struct {
+	// From and To are the first and last days to backfill, inclusive.
+	From, To time.Time
+	// Columns to export. Defaults to BackfillColumns() if empty.
+	Columns []string
+	// Opts of the exporter whose history is backfilled. Its units and labels are
+	// used, so that the backfilled series match those it exports. May be nil.
+	Opts *Opts
+	// Collector labels series with thermostat_id and thermostat_name, as the
+	// Collector does, rather than with thermostat, as scrapes of /thermostat are
+	// relabeled.
+	Collector bool
+}
+
+func (o *BackfillOpts) columns() []string {
+	if len(o.Columns) == 0 {
+		return BackfillColumns()
+	}
+	return o.Columns
+}
+
+type backfillSample struct {
+	t time.Time
+	v float64
+}
+
+// openMetrics accumulates samples, and writes them in OpenMetrics text format
+// with timestamps, as expected by `promtool tsdb create-blocks-from openmetrics`.
+type openMetrics struct {
+	families map[string]backfillFamily
+	// family name -> series labels -> samples
+	series map[string]map[string][]backfillSample
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (o *openMetrics) add(family backfillFamily, labels [][2]string, t time.Time, v float64) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, l[0], labelValueEscaper.Replace(l[1])))
+	}
+	key := strings.Join(pairs, ",")
+
+	if o.series == nil {
+		o.families = make(map[string]backfillFamily)
+		o.series = make(map[string]map[string][]backfillSample)
+	}
+	series, ok := o.series[family.name]
+	if !ok {
+		o.families[family.name] = family
+		series = make(map[string][]backfillSample)
+		o.series[family.name] = series
+	}
+	series[key] = append(series[key], backfillSample{t: t, v: v})
+}
+
+func (o *openMetrics) write(w io.Writer) error {
+	names := make([]string, 0, len(o.families))
+	for name := range o.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := o.families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %v %v\n# HELP %v %v\n", name, f.typ, name, f.help); err != nil {
+			return err
+		}
+		sampleName := name
+		if f.typ == "counter" {
+			sampleName = name + "_total"
+		}
+
+		series := o.series[name]
+		keys := make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			samples := series[key]
+			sort.Slice(samples, func(i, j int) bool { return samples[i].t.Before(samples[j].t) })
+			total := 0.0
+			for _, s := range samples {
+				v := s.v
+				if f.typ == "counter" {
+					// Report values are per interval; counters are cumulative from
+					// the start of the backfill.
+					total += v
+					v = total
+				}
+				if _, err := fmt.Fprintf(w, "%v{%v} %v %d\n", sampleName, key, strconv.FormatFloat(v, 'f', -1, 64), s.t.Unix()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "# EOF")
+	return err
+}
+
+// thermostatLocation returns the time.Location of a thermostat, used to
+// interpret the local timestamps in runtime reports.
+func thermostatLocation(l *egobee.Location) *time.Location {
+	if l.TimeZone != "" {
+		if loc, err := time.LoadLocation(l.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone(l.TimeZone, l.TimeZoneOffsetMinutes*60)
+}
+
+// backfillThermostat is what Backfill needs to know of each thermostat.
+type backfillThermostat struct {
+	loc    *time.Location
+	unit   Unit
+	labels [][2]string
+	// station is the weather station, which labels weather columns.
+	station string
+}
+
+// Backfill writes historical runtime data for all registered thermostats to w
+// as OpenMetrics text, named, labeled and in the units of the metrics exported
+// with the Opts of o.
+func Backfill(ctx context.Context, c *ecobee.Client, o *BackfillOpts, w io.Writer) error {
+	columns := o.columns()
+	for _, column := range columns {
+		if _, ok := backfillColumns[column]; !ok {
+			return fmt.Errorf("unsupported backfill column %q", column)
+		}
+	}
+	if o.To.Before(o.From) {
+		return fmt.Errorf("backfill end %v is before start %v", o.To, o.From)
+	}
+
+	thermostats, err := c.Thermostats(ctx, &egobee.Selection{
+		SelectionType:   egobee.SelectionTypeRegistered,
+		IncludeLocation: true,
+		IncludeSettings: true,
+		IncludeWeather:  true,
+	})
+	if err != nil {
+		return err
+	}
+	known := make(map[string]*backfillThermostat)
+	var ids []string
+	for _, t := range thermostats {
+		known[t.Identifier] = o.thermostat(t)
+		ids = append(ids, t.Identifier)
+	}
+
+	om := &openMetrics{}
+	const day = 24 * time.Hour
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > ecobee.RuntimeReportMaxThermostats {
+			n = ecobee.RuntimeReportMaxThermostats
+		}
+		batch := ids[:n]
+		ids = ids[n:]
+
+		for start := o.From; !start.After(o.To); {
+			end := start.Add((ecobee.RuntimeReportMaxDays - 1) * day)
+			if end.After(o.To) {
+				end = o.To
+			}
+			reports, err := c.RuntimeReport(ctx, &ecobee.RuntimeReportRequest{
+				ThermostatIdentifiers: batch,
+				StartDate:             start,
+				EndDate:               end,
+				Columns:               columns,
+			})
+			if err != nil {
+				return err
+			}
+			for _, report := range reports {
+				bt, ok := known[report.ThermostatIdentifier]
+				if !ok {
+					bt = o.thermostat(&ecobee.Thermostat{Thermostat: egobee.Thermostat{Identifier: report.ThermostatIdentifier}})
+					bt.loc = time.UTC
+				}
+				for _, row := range report.Rows {
+					t, err := row.In(bt.loc)
+					if err != nil {
+						return fmt.Errorf("thermostat %v: invalid row time: %v", report.ThermostatIdentifier, err)
+					}
+					for column, v := range row.Values {
+						bc, ok := backfillColumns[column]
+						if !ok {
+							continue
+						}
+						om.add(bc.family.inUnit(bt.unit), bt.columnLabels(bc), t, bt.value(bc, v))
+					}
+				}
+			}
+			start = end.Add(day)
+		}
+	}
+	return om.write(w)
+}
+
+// thermostat returns what Backfill needs to know of t.
+func (o *BackfillOpts) thermostat(t *ecobee.Thermostat) *backfillThermostat {
+	bt := &backfillThermostat{
+		loc:     thermostatLocation(&t.Location),
+		unit:    o.Opts.unitFor(t.Identifier).resolve(t),
+		station: t.Weather.WeatherStation,
+	}
+	if o.Collector {
+		bt.labels = [][2]string{{thermostatIDLabel, t.Identifier}, {thermostatNameLabel, t.Name}}
+	} else {
+		bt.labels = [][2]string{{"thermostat", t.Identifier}}
+	}
+	for name, value := range o.Opts.labels() {
+		bt.labels = append(bt.labels, [2]string{name, value})
+	}
+	return bt
+}
+
+// columnLabels of the series of bc.
+func (bt *backfillThermostat) columnLabels(bc backfillColumn) [][2]string {
+	labels := append([][2]string(nil), bt.labels...)
+	if bc.label != "" {
+		labels = append(labels, [2]string{bc.label, bc.val})
+	}
+	if bc.weather {
+		labels = append(labels, [2]string{"station", bt.station}, [2]string{"forecast", "0"})
+	}
+	return labels
+}
+
+// value v of bc, converted to the unit of the thermostat if it is a
+// temperature. Report temperatures are in Fahrenheit.
+func (bt *backfillThermostat) value(bc backfillColumn, v float64) float64 {
+	if bc.family.temperature {
+		return bt.unit.fromFahrenheit(v)
+	}
+	return v
+}
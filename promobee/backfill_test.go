@@ -0,0 +1,115 @@
+package promobee
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+func TestOpenMetricsWrite(t *testing.T) {
+	om := &openMetrics{}
+	t0 := time.Unix(1593604800, 0)
+	// Added out of order, to ensure samples are sorted by time.
+	om.add(equipmentRuntimeFamily, [][2]string{{"thermostat", "123"}, {"equipment", "fan"}}, t0.Add(5*time.Minute), 120)
+	om.add(equipmentRuntimeFamily, [][2]string{{"thermostat", "123"}, {"equipment", "fan"}}, t0, 300)
+	om.add(actualTemperatureFamily.inUnit(UnitCelsius), [][2]string{{"thermostat", `a"b`}}, t0, 21.5)
+
+	var buf bytes.Buffer
+	if err := om.write(&buf); err != nil {
+		t.Fatalf("write(...): unexpected error: %v", err)
+	}
+	want := `# TYPE actual_temperature_celsius gauge
+# HELP actual_temperature_celsius Temperature in Celsius used by an Ecobee thermostat, averaged over its participating sensors.
+actual_temperature_celsius{thermostat="a\"b"} 21.5 1593604800
+# TYPE equipment_runtime_seconds counter
+# HELP equipment_runtime_seconds Total seconds HVAC equipment has run, as reported in 5 minute intervals by an Ecobee thermostat.
+equipment_runtime_seconds_total{equipment="fan",thermostat="123"} 300 1593604800
+equipment_runtime_seconds_total{equipment="fan",thermostat="123"} 420 1593605100
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("write(...): got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestBackfillOptsColumns(t *testing.T) {
+	if got := (&BackfillOpts{}).columns(); len(got) != len(backfillColumns) {
+		t.Errorf("default columns: got %d, want %d", len(got), len(backfillColumns))
+	}
+	if got := (&BackfillOpts{Columns: []string{"fan"}}).columns(); len(got) != 1 || got[0] != "fan" {
+		t.Errorf("explicit columns: got %v, want [fan]", got)
+	}
+}
+
+// Backfilled series must be the very series exported live, or they cannot fill
+// its gaps.
+func TestBackfillColumns_matchLiveMetrics(t *testing.T) {
+	for _, unit := range []Unit{UnitFahrenheit, UnitCelsius} {
+		live := make(map[string]bool)
+		ch := make(chan *prometheus.Desc)
+		go func() {
+			for _, c := range newThermostatMetrics(unit, nil, nil).collectors() {
+				c.Describe(ch)
+			}
+			close(ch)
+		}()
+		for desc := range ch {
+			live[desc.String()] = true
+		}
+
+		bt := &backfillThermostat{unit: unit}
+		for _, column := range BackfillColumns() {
+			bc := backfillColumns[column]
+			f := bc.family.inUnit(unit)
+			name := f.name
+			if f.typ == "counter" {
+				name += "_total"
+			}
+			var labels []string
+			for _, l := range bt.columnLabels(bc) {
+				labels = append(labels, l[0])
+			}
+			want := fmt.Sprintf("Desc{fqName: %q, help: %q, constLabels: {}, variableLabels: %v}", name, f.help, labels)
+			if !live[want] {
+				t.Errorf("%v column %v: backfilled as %v, which is not exported live", unit, column, want)
+			}
+		}
+	}
+}
+
+func TestBackfillOpts_thermostat(t *testing.T) {
+	th := &ecobee.Thermostat{Thermostat: egobee.Thermostat{Identifier: "123", Name: "Home"}}
+	th.Weather.WeatherStation = "ws:1"
+	o := &BackfillOpts{Opts: &Opts{
+		Unit:            UnitFahrenheit,
+		ThermostatUnits: map[string]Unit{"123": UnitCelsius},
+		Labels:          map[string]string{"house": "main"},
+	}}
+
+	bt := o.thermostat(th)
+	if got := bt.value(backfillColumns["zoneAveTemp"], 71.6); got != 22 {
+		t.Errorf("zoneAveTemp in thermostat unit: got %v, want 22", got)
+	}
+	if got := bt.value(backfillColumns["outdoorHumidity"], 40); got != 40 {
+		t.Errorf("outdoorHumidity: got %v, want 40", got)
+	}
+	if got, want := fmt.Sprint(bt.columnLabels(backfillColumns["outdoorTemp"])), "[[thermostat 123] [house main] [station ws:1] [forecast 0]]"; got != want {
+		t.Errorf("outdoorTemp labels: got %v, want %v", got, want)
+	}
+
+	o.Collector = true
+	o.Opts = nil
+	bt = o.thermostat(th)
+	if got := bt.value(backfillColumns["zoneAveTemp"], 71.6); got != 71.6 {
+		t.Errorf("zoneAveTemp by default: got %v, want 71.6", got)
+	}
+	if got, want := fmt.Sprint(bt.columnLabels(backfillColumns["zoneHeatTemp"])), "[[thermostat_id 123] [thermostat_name Home] [type heat]]"; got != want {
+		t.Errorf("zoneHeatTemp labels in collector mode: got %v, want %v", got, want)
+	}
+}
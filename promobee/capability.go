@@ -0,0 +1,127 @@
+package promobee
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Capability describes how the values of a type of sensor capability are
+// exported.
+type Capability struct {
+	// Unit of the exported value, if known.
+	Unit string
+	// Scale divides the value reported by the API, if set. The API reports
+	// every known type in its unit, so Scale is only set by configuration, such
+	// as to export airPressure in kPa.
+	Scale float64
+}
+
+// capabilities are the known types of sensor capability, which may be
+// overridden by Sensors.Capabilities. The API reports each in the unit given,
+// so none is scaled. Values of other types are exported unscaled and without a
+// unit.
+var capabilities = map[string]Capability{
+	"airPressure":        {Unit: "hPa"},
+	"airQuality":         {Unit: "score"},
+	"airQualityAccuracy": {Unit: "level"},
+	"co2":                {Unit: "ppm"},
+	"co2PPM":             {Unit: "ppm"},
+	"vocPPM":             {Unit: "ppb"},
+	"dryContact":         {Unit: "bool"},
+}
+
+// dedicatedCapabilities are exported by metrics of their own, such as
+// temperature_fahrenheit, rather than as a sensor_capability.
+var dedicatedCapabilities = map[string]bool{
+	egobee.CapabilityTypeTemperature: true,
+	egobee.CapabilityTypeHumidity:    true,
+	egobee.CapabilityTypeOccupancy:   true,
+}
+
+// value of c, which may be numeric or boolean, scaled.
+func (t Capability) value(c *egobee.RemoteSensorCapability) (float64, bool) {
+	var v float64
+	switch c.Value {
+	case "true":
+		v = 1
+	case "false":
+		v = 0
+	default:
+		f, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return 0, false
+		}
+		v = f
+	}
+	if t.Scale != 0 {
+		v /= t.Scale
+	}
+	return v, true
+}
+
+// loggedTypes are the types of capability of which a value could not be
+// exported. Each type is only logged once, as its values are usually reported by
+// every poll.
+var loggedTypes struct {
+	sync.Mutex
+	m map[string]bool
+}
+
+// logValueOnce logs the value of c, reported by the sensor named sensor, unless
+// a value of the same type has already been logged.
+func logValueOnce(sensor string, c *egobee.RemoteSensorCapability) {
+	loggedTypes.Lock()
+	defer loggedTypes.Unlock()
+	if loggedTypes.m[c.Type] {
+		return
+	}
+	if loggedTypes.m == nil {
+		loggedTypes.m = make(map[string]bool)
+	}
+	loggedTypes.m[c.Type] = true
+	log.Printf("Not exporting %v capability of %q: non-numeric value %q. Other values of the type are not logged.", c.Type, sensor, c.Value)
+}
+
+// capabilityMetrics export every numeric capability of each sensor which is not
+// exported by a metric of its own, such as air quality.
+type capabilityMetrics struct {
+	value *prometheus.GaugeVec
+}
+
+// newCapabilityMetrics which identify sensors by sensorLabel.
+func newCapabilityMetrics(sensorLabel string) *capabilityMetrics {
+	return &capabilityMetrics{
+		value: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sensor_capability",
+				Help: "Value of a capability of an Ecobee sensor, such as airQuality or co2, in the unit of the unit label if known. Booleans are '1' or '0'.",
+			},
+			[]string{sensorLabel, "capability", "unit"}),
+	}
+}
+
+func (m *capabilityMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.value}
+}
+
+// export the capabilities of sensor, which is identified by id, as configured
+// by sensors.
+func (m *capabilityMetrics) export(id string, sensor *egobee.RemoteSensor, sensors *Sensors) {
+	for i := range sensor.Capability {
+		c := &sensor.Capability[i]
+		if dedicatedCapabilities[c.Type] {
+			continue
+		}
+		t := sensors.capability(c.Type)
+		v, ok := t.value(c)
+		if !ok {
+			logValueOnce(sensor.Name, c)
+			continue
+		}
+		m.value.WithLabelValues(id, c.Type, t.Unit).Set(v)
+	}
+}
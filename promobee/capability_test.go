@@ -0,0 +1,99 @@
+package promobee
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestCapabilityMetrics_export(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	loggedTypes.Lock()
+	loggedTypes.m = nil
+	loggedTypes.Unlock()
+
+	sensor := &egobee.RemoteSensor{
+		ID:   "ei:0",
+		Name: "Living Room",
+		Capability: []egobee.RemoteSensorCapability{
+			{Type: egobee.CapabilityTypeTemperature, Value: "721"},
+			{Type: egobee.CapabilityTypeOccupancy, Value: "true"},
+			{Type: "airQuality", Value: "21"},
+			{Type: "co2PPM", Value: "670"},
+			{Type: "airPressure", Value: "1013"},
+			{Type: "dryContact", Value: "true"},
+			{Type: "radon", Value: "3.5"},
+			{Type: "airQualityAccuracy", Value: "calibrating"},
+		},
+	}
+	sensors := &Sensors{Capabilities: map[string]Capability{"airPressure": {Unit: "kPa", Scale: 10}}}
+	for i := 0; i < 2; i++ {
+		m := newCapabilityMetrics("location")
+		m.export("Living Room", sensor, sensors)
+		// The accuracy changes, but the type is still only logged once.
+		sensor.Capability[7].Value = "stabilizing"
+
+		for _, tt := range []struct {
+			capability, unit string
+			want             float64
+		}{
+			{capability: "airQuality", unit: "score", want: 21},
+			{capability: "co2PPM", unit: "ppm", want: 670},
+			{capability: "airPressure", unit: "kPa", want: 101.3},
+			{capability: "dryContact", unit: "bool", want: 1},
+			// Unknown types are exported without a unit.
+			{capability: "radon", unit: "", want: 3.5},
+		} {
+			if got := gaugeValue(t, m.value.WithLabelValues("Living Room", tt.capability, tt.unit)); got != tt.want {
+				t.Errorf("sensor_capability{%v, %v}: got %v, want %v", tt.capability, tt.unit, got, tt.want)
+			}
+		}
+		// Temperature and occupancy have metrics of their own, and the accuracy
+		// is not numeric.
+		if got := seriesCount(t, m.value); got != 5 {
+			t.Errorf("sensor_capability: got %d series, want 5", got)
+		}
+	}
+	if got := strings.Count(logs.String(), "airQualityAccuracy"); got != 1 {
+		t.Errorf("non-numeric values logged %d times, want once:\n%v", got, logs.String())
+	}
+}
+
+func TestCapabilityMetrics_exportScaled(t *testing.T) {
+	sensor := &egobee.RemoteSensor{
+		Name:       "Living Room",
+		Capability: []egobee.RemoteSensorCapability{{Type: "co2PPM", Value: "670"}, {Type: "airPressure", Value: "1013"}},
+	}
+	// Known types are exported as reported by the API.
+	m := newCapabilityMetrics("location")
+	m.export("Living Room", sensor, nil)
+	if got := gaugeValue(t, m.value.WithLabelValues("Living Room", "airPressure", "hPa")); got != 1013 {
+		t.Errorf("sensor_capability{airPressure, hPa}: got %v, want 1013", got)
+	}
+
+	// Configured scales apply to known types too.
+	m = newCapabilityMetrics("location")
+	m.export("Living Room", sensor, &Sensors{Capabilities: map[string]Capability{"co2PPM": {Unit: "%", Scale: 10000}}})
+	if got := gaugeValue(t, m.value.WithLabelValues("Living Room", "co2PPM", "%")); got != 0.067 {
+		t.Errorf("sensor_capability{co2PPM, %%}: got %v, want 0.067", got)
+	}
+}
+
+func TestSensors_capability(t *testing.T) {
+	if got := (*Sensors)(nil).capability("co2"); got.Unit != "ppm" {
+		t.Errorf("nil capability(co2): got %+v, want unit ppm", got)
+	}
+	s := &Sensors{Capabilities: map[string]Capability{"co2": {Unit: "%", Scale: 10000}}}
+	if got := s.capability("co2"); got.Unit != "%" || got.Scale != 10000 {
+		t.Errorf("capability(co2): got %+v, want it overridden", got)
+	}
+	if got := s.capability("vocPPM"); got.Unit != "ppb" {
+		t.Errorf("capability(vocPPM): got %+v, want unit ppb", got)
+	}
+}
@@ -0,0 +1,79 @@
+package promobee
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Labels identifying the thermostat of each metric emitted by the Collector.
+const (
+	thermostatIDLabel   = "thermostat_id"
+	thermostatNameLabel = "thermostat_name"
+)
+
+// thermostatCollector emits the metrics of every thermostat known to an
+// Accumulator, labeled with the identifier and name of the thermostat.
+type thermostatCollector struct {
+	a *Accumulator
+}
+
+// Collector returns a prometheus.Collector which emits the metrics of every
+// thermostat, for use where scrapes of /thermostat?id= cannot be relabeled.
+// It is safe to register alongside other collectors and scrape concurrently.
+func (a *Accumulator) Collector() prometheus.Collector {
+	return &thermostatCollector{a: a}
+}
+
+// Describe sends nothing, since the metrics of each thermostat are not known
+// until it has been polled. This makes the Collector unchecked.
+func (*thermostatCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *thermostatCollector) Collect(ch chan<- prometheus.Metric) {
+	for id, t := range c.a.current().thermostats {
+		families, err := t.registry.Gather()
+		if err != nil {
+			desc := prometheus.NewDesc("promobee_thermostat_collector_error", "Error gathering thermostat metrics.", nil, prometheus.Labels{thermostatIDLabel: id})
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+		for _, family := range families {
+			relabel(ch, family, id, t.name)
+		}
+	}
+}
+
+// relabel sends each metric of family to ch, with thermostat labels added.
+func relabel(ch chan<- prometheus.Metric, family *dto.MetricFamily, id, name string) {
+	var valueType prometheus.ValueType
+	switch family.GetType() {
+	case dto.MetricType_GAUGE:
+		valueType = prometheus.GaugeValue
+	case dto.MetricType_COUNTER:
+		valueType = prometheus.CounterValue
+	default:
+		desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), nil, nil)
+		ch <- prometheus.NewInvalidMetric(desc, fmt.Errorf("unsupported metric type %v", family.GetType()))
+		return
+	}
+	for _, metric := range family.Metric {
+		names := []string{thermostatIDLabel, thermostatNameLabel}
+		values := []string{id, name}
+		for _, l := range metric.Label {
+			names = append(names, l.GetName())
+			values = append(values, l.GetValue())
+		}
+		value := metric.GetGauge().GetValue()
+		if valueType == prometheus.CounterValue {
+			value = metric.GetCounter().GetValue()
+		}
+		desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), names, nil)
+		m, err := prometheus.NewConstMetric(desc, valueType, value, values...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,75 @@
+package promobee
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestAccumulator_Collector(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(a.Collector())
+
+	// Scrapes may happen concurrently with each other.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := registry.Gather(); err != nil {
+				t.Errorf("Gather(): unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): unexpected error: %v", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "temperature_fahrenheit" {
+			continue
+		}
+		found = true
+		if len(family.Metric) != 1 {
+			t.Fatalf("temperature_fahrenheit: got %d series, want 1", len(family.Metric))
+		}
+		m := family.Metric[0]
+		if got := labelValue(m, thermostatIDLabel); got != "123" {
+			t.Errorf("thermostat_id: got %q, want %q", got, "123")
+		}
+		if got := labelValue(m, thermostatNameLabel); got != "Home" {
+			t.Errorf("thermostat_name: got %q, want %q", got, "Home")
+		}
+		if got := labelValue(m, "location"); got != "Kitchen" {
+			t.Errorf("location: got %q, want %q", got, "Kitchen")
+		}
+		if got := m.GetGauge().GetValue(); got != 72.5 {
+			t.Errorf("temperature_fahrenheit: got %v, want 72.5", got)
+		}
+	}
+	if !found {
+		t.Errorf("Gather(): no temperature_fahrenheit family in %v", families)
+	}
+}
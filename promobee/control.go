@@ -0,0 +1,291 @@
+package promobee
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// Controller serves authenticated endpoints which modify thermostats, at
+// paths of the form /thermostat/{id}/{action}.
+type Controller struct {
+	// accumulator which polls the thermostat identified by id in the request
+	// path, and its identifier as known to that accumulator.
+	accumulator func(id string) (*Accumulator, string, error)
+	token       string
+}
+
+// NewController which accepts requests bearing token. Controller uses the same
+// client as the Accumulator, so that both share a single token store, and reads
+// temperatures in the unit in which the Accumulator exports them.
+func NewController(a *Accumulator, token string) *Controller {
+	return &Controller{
+		accumulator: func(id string) (*Accumulator, string, error) { return a, id, nil },
+		token:       token,
+	}
+}
+
+// holdRequest is the body of a hold request. Temperatures are in Unit, which
+// defaults to the unit in which the temperatures of the thermostat are
+// exported. Dates and times are in thermostat time, and only apply to a
+// dateTime hold.
+type holdRequest struct {
+	HeatHoldTemp   *float64        `json:"heatHoldTemp"`
+	CoolHoldTemp   *float64        `json:"coolHoldTemp"`
+	Unit           Unit            `json:"unit"`
+	HoldClimateRef string          `json:"holdClimateRef"`
+	HoldType       ecobee.HoldType `json:"holdType"`
+	HoldHours      int             `json:"holdHours"`
+	StartDate      string          `json:"startDate"`
+	StartTime      string          `json:"startTime"`
+	EndDate        string          `json:"endDate"`
+	EndTime        string          `json:"endTime"`
+	Fan            string          `json:"fan"`
+}
+
+// tenths of a degree Fahrenheit, as the API expects, of the temperature t in
+// the unit of the request, or nil if t is.
+func (r *holdRequest) tenths(t *float64) *int {
+	if t == nil {
+		return nil
+	}
+	f := int(math.Round(r.Unit.toFahrenheit(*t) * 10))
+	return &f
+}
+
+// function which sets the hold, with temperatures in unit unless the request
+// has a unit of its own. Temperatures must be within setpoints, if known.
+func (r *holdRequest) function(unit Unit, setpoints *setpointRange) (ecobee.Function, error) {
+	switch r.Unit {
+	case "":
+		r.Unit = unit
+	case UnitFahrenheit, UnitCelsius:
+	default:
+		return ecobee.Function{}, fmt.Errorf("invalid unit %q; must be fahrenheit or celsius", r.Unit)
+	}
+	p := &ecobee.SetHoldParams{
+		HeatHoldTemp:   r.tenths(r.HeatHoldTemp),
+		CoolHoldTemp:   r.tenths(r.CoolHoldTemp),
+		HoldClimateRef: r.HoldClimateRef,
+		HoldType:       r.HoldType,
+		HoldHours:      r.HoldHours,
+		StartDate:      r.StartDate,
+		StartTime:      r.StartTime,
+		EndDate:        r.EndDate,
+		EndTime:        r.EndTime,
+		Fan:            r.Fan,
+	}
+	if err := p.Validate(); err != nil {
+		return ecobee.Function{}, err
+	}
+	if err := setpoints.check(p, r.Unit); err != nil {
+		return ecobee.Function{}, err
+	}
+	return ecobee.SetHold(p), nil
+}
+
+// setpointRange is the range of each hold temperature accepted by a
+// thermostat, in tenths of a degree Fahrenheit, as reported by its settings.
+type setpointRange struct {
+	heatLow, heatHigh int
+	coolLow, coolHigh int
+}
+
+// newSetpointRange from settings, or nil if they report no range.
+func newSetpointRange(s *egobee.Settings) *setpointRange {
+	if s.HeatRangeHigh == 0 && s.CoolRangeHigh == 0 {
+		return nil
+	}
+	return &setpointRange{
+		heatLow:  s.HeatRangeLow,
+		heatHigh: s.HeatRangeHigh,
+		coolLow:  s.CoolRangeLow,
+		coolHigh: s.CoolRangeHigh,
+	}
+}
+
+// check that the temperatures of p are within the range, which is reported in
+// unit if they are not. A nil range accepts any temperature, leaving the API to
+// reject those the thermostat does not.
+func (sr *setpointRange) check(p *ecobee.SetHoldParams, unit Unit) error {
+	if sr == nil {
+		return nil
+	}
+	if err := checkSetpoint("heatHoldTemp", p.HeatHoldTemp, sr.heatLow, sr.heatHigh, unit); err != nil {
+		return err
+	}
+	return checkSetpoint("coolHoldTemp", p.CoolHoldTemp, sr.coolLow, sr.coolHigh, unit)
+}
+
+func checkSetpoint(name string, t *int, low, high int, unit Unit) error {
+	if t == nil || (*t >= low && *t <= high) {
+		return nil
+	}
+	degrees := func(t int) float64 { return unit.fromFahrenheit(float64(t) / 10) }
+	return fmt.Errorf("%v %v is outside the range of the thermostat, %v to %v degrees %v", name, degrees(*t), degrees(low), degrees(high), unit.title())
+}
+
+// resumeRequest is the body of a resume request. The body is optional.
+type resumeRequest struct {
+	ResumeAll bool `json:"resumeAll"`
+}
+
+// occupiedRequest is the body of an occupied request.
+type occupiedRequest struct {
+	Occupied  bool            `json:"occupied"`
+	HoldType  ecobee.HoldType `json:"holdType"`
+	HoldHours int             `json:"holdHours"`
+}
+
+// acknowledgeRequest is the body of an acknowledge request. AckRef is the
+// acknowledge_ref label of the alert_info metric.
+type acknowledgeRequest struct {
+	AckRef        string         `json:"ackRef"`
+	AckType       ecobee.AckType `json:"ackType"`
+	RemindMeLater bool           `json:"remindMeLater"`
+}
+
+func (c *Controller) authorized(req *http.Request) bool {
+	want := "Bearer " + c.token
+	got := req.Header.Get("Authorization")
+	return c.token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// decodeBody into v. An empty body leaves v unchanged if optional.
+func decodeBody(req *http.Request, v interface{}, optional bool) error {
+	if optional && req.ContentLength == 0 {
+		return nil
+	}
+	d := json.NewDecoder(req.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// parseControlPath splits a path of the form /thermostat/{id}/{action}.
+func parseControlPath(path string) (id, action string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/thermostat/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// badRequestError is caused by the request, rather than the Ecobee API.
+type badRequestError struct {
+	error
+}
+
+var errUnknownAction = errors.New("unknown action")
+
+// call the API with the client of a to perform action on the thermostat
+// identified by id.
+func call(a *Accumulator, id, action string, req *http.Request) error {
+	ctx := req.Context()
+	client := a.client
+	selection := ecobee.SelectThermostat(id)
+	switch action {
+	case "hold":
+		hr := &holdRequest{}
+		if err := decodeBody(req, hr, false); err != nil {
+			return badRequestError{err}
+		}
+		f, err := hr.function(a.unit(id), a.setpoints(id))
+		if err != nil {
+			return badRequestError{err}
+		}
+		return client.CallFunctions(ctx, selection, f)
+	case "resume":
+		rr := &resumeRequest{}
+		if err := decodeBody(req, rr, true); err != nil {
+			return badRequestError{err}
+		}
+		return client.CallFunctions(ctx, selection, ecobee.ResumeProgram(rr.ResumeAll))
+	case "occupied":
+		or := &occupiedRequest{}
+		if err := decodeBody(req, or, false); err != nil {
+			return badRequestError{err}
+		}
+		p := &ecobee.SetOccupiedParams{Occupied: or.Occupied, HoldType: or.HoldType, HoldHours: or.HoldHours}
+		if err := p.Validate(); err != nil {
+			return badRequestError{err}
+		}
+		return client.CallFunctions(ctx, selection, ecobee.SetOccupied(p))
+	case "acknowledge":
+		ar := &acknowledgeRequest{}
+		if err := decodeBody(req, ar, false); err != nil {
+			return badRequestError{err}
+		}
+		p := &ecobee.AcknowledgeParams{
+			ThermostatIdentifier: id,
+			AckRef:               ar.AckRef,
+			AckType:              ar.AckType,
+			RemindMeLater:        ar.RemindMeLater,
+		}
+		if err := p.Validate(); err != nil {
+			return badRequestError{err}
+		}
+		return client.CallFunctions(ctx, selection, ecobee.Acknowledge(p))
+	case "mode":
+		patch := &ecobee.SettingsPatch{}
+		if err := decodeBody(req, patch, false); err != nil {
+			return badRequestError{err}
+		}
+		if err := patch.Validate(); err != nil {
+			return badRequestError{err}
+		}
+		return client.UpdateSettings(ctx, selection, patch)
+	}
+	return errUnknownAction
+}
+
+// ServeHTTP handles POST /thermostat/{id}/hold, /resume, /mode, /occupied and
+// /acknowledge.
+func (c *Controller) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id, action, ok := parseControlPath(req.URL.Path)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.authorized(req) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	a, id, err := c.accumulator(id)
+	if err != nil {
+		http.Error(w, err.Error(), findStatus(err))
+		return
+	}
+	if err := call(a, id, action, req); err != nil {
+		if _, ok := err.(badRequestError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err == errUnknownAction {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error calling %v on thermostat %q: %v", action, id, err)
+		http.Error(w, fmt.Sprintf("Ecobee API error: %v", err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "OK")
+}
@@ -0,0 +1,170 @@
+package promobee
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+func TestParseControlPath(t *testing.T) {
+	for _, tt := range []struct {
+		path, id, action string
+		ok               bool
+	}{
+		{path: "/thermostat/123/hold", id: "123", action: "hold", ok: true},
+		{path: "/thermostat/123"},
+		{path: "/thermostat//hold"},
+		{path: "/thermostat/123/hold/extra"},
+	} {
+		id, action, ok := parseControlPath(tt.path)
+		if id != tt.id || action != tt.action || ok != tt.ok {
+			t.Errorf("parseControlPath(%q): got %q, %q, %v", tt.path, id, action, ok)
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	var functions []ecobee.Function
+	a, srv := testAccumulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := &struct {
+			Functions []ecobee.Function `json:"functions"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+			t.Errorf("failed decoding request: %v", err)
+		}
+		functions = append(functions, u.Functions...)
+		fmt.Fprint(w, `{"status": {"code": 0}}`)
+	}))
+	defer srv.Close()
+	c := NewController(a, "secret")
+
+	for _, tt := range []struct {
+		name, method, path, token, body string
+		want                            int
+	}{
+		{name: "hold", method: http.MethodPost, path: "/thermostat/123/hold", token: "secret", body: `{"heatHoldTemp": 68, "coolHoldTemp": 76.5, "holdType": "holdHours", "holdHours": 2}`, want: http.StatusOK},
+		{name: "resume without body", method: http.MethodPost, path: "/thermostat/123/resume", token: "secret", want: http.StatusOK},
+		{name: "acknowledge", method: http.MethodPost, path: "/thermostat/123/acknowledge", token: "secret", body: `{"ackRef": "abc", "ackType": "accept"}`, want: http.StatusOK},
+		{name: "bad acknowledge", method: http.MethodPost, path: "/thermostat/123/acknowledge", token: "secret", body: `{"ackRef": "abc", "ackType": "ignore"}`, want: http.StatusBadRequest},
+		{name: "mode", method: http.MethodPost, path: "/thermostat/123/mode", token: "secret", body: `{"hvacMode": "off"}`, want: http.StatusOK},
+		{name: "bad mode", method: http.MethodPost, path: "/thermostat/123/mode", token: "secret", body: `{"hvacMode": "warm"}`, want: http.StatusBadRequest},
+		{name: "dateTime without dates", method: http.MethodPost, path: "/thermostat/123/hold", token: "secret", body: `{"holdClimateRef": "away", "holdType": "dateTime"}`, want: http.StatusBadRequest},
+		{name: "occupied dateTime", method: http.MethodPost, path: "/thermostat/123/occupied", token: "secret", body: `{"occupied": false, "holdType": "dateTime"}`, want: http.StatusBadRequest},
+		{name: "unknown field", method: http.MethodPost, path: "/thermostat/123/hold", token: "secret", body: `{"temp": 68}`, want: http.StatusBadRequest},
+		{name: "wrong token", method: http.MethodPost, path: "/thermostat/123/hold", token: "guess", body: `{}`, want: http.StatusUnauthorized},
+		{name: "no token", method: http.MethodPost, path: "/thermostat/123/resume", want: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, path: "/thermostat/123/resume", token: "secret", want: http.StatusMethodNotAllowed},
+		{name: "unknown action", method: http.MethodPost, path: "/thermostat/123/explode", token: "secret", want: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%v: got status %v, want %v (%v)", tt.name, rr.Code, tt.want, rr.Body.String())
+		}
+	}
+
+	// Only the successful function requests should reach the API.
+	if len(functions) != 3 {
+		t.Fatalf("got %d functions called, want 3: %+v", len(functions), functions)
+	}
+	if functions[0].Type != "setHold" || functions[1].Type != "resumeProgram" || functions[2].Type != "acknowledge" {
+		t.Errorf("got functions %+v, want setHold, resumeProgram then acknowledge", functions)
+	}
+	if ack := functions[2].Params.(map[string]interface{}); ack["thermostatIdentifier"] != "123" || ack["ackRef"] != "abc" {
+		t.Errorf("acknowledge: got params %v", ack)
+	}
+	hold := functions[0].Params.(map[string]interface{})
+	if hold["heatHoldTemp"] != 680.0 || hold["coolHoldTemp"] != 765.0 {
+		t.Errorf("setHold: got params %v, want temperatures in tenths of a degree", hold)
+	}
+}
+
+func TestController_apiError(t *testing.T) {
+	a, srv := testAccumulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"status": {"code": 3, "message": "Validation error."}}`)
+	}))
+	defer srv.Close()
+	c := NewController(a, "secret")
+
+	req := httptest.NewRequest(http.MethodPost, "/thermostat/123/hold", strings.NewReader(`{"holdClimateRef": "away"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	c.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusBadGateway)
+	}
+}
+
+func TestController_holdUnit(t *testing.T) {
+	var params []map[string]interface{}
+	a, srv := testAccumulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := &struct {
+			Functions []ecobee.Function `json:"functions"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+			t.Errorf("failed decoding request: %v", err)
+		}
+		for _, f := range u.Functions {
+			params = append(params, f.Params.(map[string]interface{}))
+		}
+		fmt.Fprint(w, `{"status": {"code": 0}}`)
+	}))
+	defer srv.Close()
+	// 123 is exported in Celsius because it was configured so, and 456 because
+	// it was polled with UnitAuto and uses Celsius. 789 is exported in the
+	// default Fahrenheit. Only the settings of 456, and so its range, are known.
+	a.opts = &Opts{ThermostatUnits: map[string]Unit{"123": UnitCelsius, "456": UnitAuto}}
+	polled := newThermostatMetrics(UnitCelsius, nil, nil)
+	polled.setpoints = newSetpointRange(&egobee.Settings{HeatRangeLow: 450, HeatRangeHigh: 790, CoolRangeLow: 650, CoolRangeHigh: 920})
+	a.snapshot.Store(&snapshot{thermostats: map[string]*thermostatMetrics{"456": polled}})
+	c := NewController(a, "secret")
+
+	for _, tt := range []struct {
+		name, id, body string
+		want           int
+		heat, cool     float64
+	}{
+		{name: "configured celsius", id: "123", body: `{"heatHoldTemp": 20, "coolHoldTemp": 24.5}`, want: http.StatusOK, heat: 680, cool: 761},
+		{name: "auto celsius", id: "456", body: `{"heatHoldTemp": 21, "coolHoldTemp": 25}`, want: http.StatusOK, heat: 698, cool: 770},
+		{name: "default fahrenheit", id: "789", body: `{"heatHoldTemp": 68, "coolHoldTemp": 76}`, want: http.StatusOK, heat: 680, cool: 760},
+		{name: "explicit fahrenheit", id: "123", body: `{"heatHoldTemp": 68, "coolHoldTemp": 76, "unit": "fahrenheit"}`, want: http.StatusOK, heat: 680, cool: 760},
+		{name: "explicit celsius", id: "789", body: `{"heatHoldTemp": 0, "coolHoldTemp": 25, "unit": "celsius"}`, want: http.StatusOK, heat: 320, cool: 770},
+		// A temperature of 0 is sent, rather than omitted and ignored by the API.
+		{name: "zero fahrenheit", id: "789", body: `{"heatHoldTemp": 0, "coolHoldTemp": 76}`, want: http.StatusOK, heat: 0, cool: 760},
+		{name: "zero celsius", id: "123", body: `{"heatHoldTemp": -17.8, "coolHoldTemp": 25}`, want: http.StatusOK, heat: 0, cool: 770},
+		{name: "within range", id: "456", body: `{"heatHoldTemp": 7.5, "coolHoldTemp": 33}`, want: http.StatusOK, heat: 455, cool: 914},
+		{name: "above range", id: "456", body: `{"heatHoldTemp": 30, "coolHoldTemp": 32}`, want: http.StatusBadRequest},
+		{name: "below range", id: "456", body: `{"heatHoldTemp": 68, "coolHoldTemp": 60, "unit": "fahrenheit"}`, want: http.StatusBadRequest},
+		{name: "missing temperature", id: "789", body: `{"heatHoldTemp": 68}`, want: http.StatusBadRequest},
+		{name: "auto", id: "123", body: `{"heatHoldTemp": 20, "coolHoldTemp": 24, "unit": "auto"}`, want: http.StatusBadRequest},
+		{name: "kelvin", id: "123", body: `{"heatHoldTemp": 293, "coolHoldTemp": 297, "unit": "kelvin"}`, want: http.StatusBadRequest},
+	} {
+		params = nil
+		req := httptest.NewRequest(http.MethodPost, "/thermostat/"+tt.id+"/hold", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		c.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%v: got status %v, want %v (%v)", tt.name, rr.Code, tt.want, rr.Body.String())
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		if len(params) != 1 || params[0]["heatHoldTemp"] != tt.heat || params[0]["coolHoldTemp"] != tt.cool {
+			t.Errorf("%v: got setHold params %v, want heatHoldTemp %v and coolHoldTemp %v", tt.name, params, tt.heat, tt.cool)
+		}
+	}
+}
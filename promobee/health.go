@@ -0,0 +1,90 @@
+package promobee
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// defaultStalePolls is the number of poll intervals after the last successful
+// poll at which the Accumulator is no longer ready.
+const defaultStalePolls = 3
+
+// health of the Accumulator, as of the most recent poll.
+type health struct {
+	mu          sync.Mutex // protects following members
+	lastSuccess time.Time
+	lastErr     error
+}
+
+func (h *health) record(err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+	if err == nil {
+		h.lastSuccess = now
+	}
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Ready              bool       `json:"ready"`
+	Reason             string     `json:"reason,omitempty"`
+	LastSuccessfulPoll *time.Time `json:"lastSuccessfulPoll,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+}
+
+// readiness of the Accumulator at now.
+func (a *Accumulator) readiness(now time.Time) *readiness {
+	a.health.mu.Lock()
+	lastSuccess, lastErr := a.health.lastSuccess, a.health.lastErr
+	a.health.mu.Unlock()
+
+	r := &readiness{}
+	if !lastSuccess.IsZero() {
+		r.LastSuccessfulPoll = &lastSuccess
+	}
+	if lastErr != nil {
+		r.LastError = lastErr.Error()
+	}
+	o := a.options()
+	stale := time.Duration(o.stalePolls()) * o.pollInterval()
+	switch {
+	case ecobee.IsInvalidGrant(lastErr):
+		r.Reason = "refresh token revoked, run `promobee register`"
+	case lastSuccess.IsZero():
+		r.Reason = "waiting for the first successful poll"
+	case now.Sub(lastSuccess) > stale:
+		r.Reason = fmt.Sprintf("last successful poll was %v ago, more than %v", now.Sub(lastSuccess).Round(time.Second), stale)
+	default:
+		r.Ready = true
+	}
+	return r
+}
+
+func serveJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ServeHealthz is a http.HandlerFunc which reports that the process is alive.
+func (a *Accumulator) ServeHealthz(w http.ResponseWriter, _ *http.Request) {
+	serveJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ServeReadyz is a http.HandlerFunc which reports whether the exported metrics
+// are current. It fails until the first successful poll, once the refresh token
+// has been revoked, and when polls have been failing for too long.
+func (a *Accumulator) ServeReadyz(w http.ResponseWriter, _ *http.Request) {
+	r := a.readiness(time.Now())
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	serveJSON(w, code, r)
+}
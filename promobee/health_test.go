@@ -0,0 +1,83 @@
+package promobee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccumulator_readiness(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name        string
+		lastSuccess time.Time
+		lastErr     error
+		wantReady   bool
+		wantReason  string
+	}{
+		{name: "never polled", wantReason: "first successful poll"},
+		{name: "first poll failed", lastErr: errors.New("oops"), wantReason: "first successful poll"},
+		{name: "fresh", lastSuccess: now.Add(-time.Minute), wantReady: true},
+		{name: "failing but not yet stale", lastSuccess: now.Add(-5 * time.Minute), lastErr: errors.New("oops"), wantReady: true},
+		{name: "stale", lastSuccess: now.Add(-10 * time.Minute), lastErr: errors.New("oops"), wantReason: "last successful poll was 10m0s ago"},
+		{
+			name:        "revoked",
+			lastSuccess: now.Add(-time.Minute),
+			lastErr:     errors.New("unable to re-authenticate: invalid_grant: The authorization grant is invalid"),
+			wantReason:  "promobee register",
+		},
+	} {
+		a := &Accumulator{}
+		a.health.lastSuccess = tt.lastSuccess
+		a.health.lastErr = tt.lastErr
+		got := a.readiness(now)
+		if got.Ready != tt.wantReady {
+			t.Errorf("%v: got ready %v, want %v", tt.name, got.Ready, tt.wantReady)
+		}
+		if !strings.Contains(got.Reason, tt.wantReason) {
+			t.Errorf("%v: got reason %q, want it to contain %q", tt.name, got.Reason, tt.wantReason)
+		}
+	}
+}
+
+func TestAccumulator_ServeReadyz(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+
+	rr := httptest.NewRecorder()
+	a.ServeReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("before polling: got status %v, want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	a.ServeReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("after polling: got status %v, want %v", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got %q, want application/json", ct)
+	}
+	r := &readiness{}
+	if err := json.Unmarshal(rr.Body.Bytes(), r); err != nil {
+		t.Fatalf("invalid body %q: %v", rr.Body.String(), err)
+	}
+	if !r.Ready || r.LastSuccessfulPoll == nil {
+		t.Errorf("after polling: got %+v, want ready with a last successful poll", r)
+	}
+
+	rr = httptest.NewRecorder()
+	a.ServeHealthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("healthz: got status %v, want %v", rr.Code, http.StatusOK)
+	}
+}
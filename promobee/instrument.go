@@ -0,0 +1,91 @@
+package promobee
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics describing promobee itself, rather than thermostats. These are
+// exported on /metrics, so that a wedged exporter can be told apart from a
+// house whose temperature is not changing. Those describing polls are labeled
+// with the account polled, which is empty unless there are several.
+var (
+	pollPagesFetched = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "promobee",
+		Name:      "poll_pages_fetched",
+		Help:      "Number of pages of thermostats fetched from the Ecobee API during the most recent poll.",
+	}, []string{"account"})
+
+	pollTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "promobee",
+		Name:      "poll_total",
+		Help:      "Number of polls of the Ecobee API, by result.",
+	}, []string{"account", "result"})
+
+	pollDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "promobee",
+		Name:      "poll_duration_seconds",
+		Help:      "Time taken to poll the Ecobee API, including all thermostat fetches.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"account"})
+
+	lastSuccessfulPoll = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "promobee",
+		Name:      "last_successful_poll_timestamp_seconds",
+		Help:      "Time at which the Ecobee API was last polled without error.",
+	}, []string{"account"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "promobee",
+		Name:      "api_request_duration_seconds",
+		Help:      "Time taken by requests to the Ecobee API, by endpoint and HTTP status code. The code is 'error' if no response was received.",
+	}, []string{"endpoint", "code"})
+
+	tokenValidSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "promobee",
+		Name:      "token_valid_seconds",
+		Help:      "Seconds for which the Ecobee API access token remained valid, as of the most recent poll. Negative once expired.",
+	}, []string{"account"})
+)
+
+func init() {
+	prometheus.MustRegister(pollPagesFetched, pollTotal, pollDuration, lastSuccessfulPoll, apiRequestDuration, tokenValidSeconds)
+}
+
+// observePoll records the outcome of a poll of account which began at start.
+func observePoll(account string, start time.Time, err error) {
+	pollDuration.WithLabelValues(account).Observe(time.Since(start).Seconds())
+	if err != nil {
+		pollTotal.WithLabelValues(account, "error").Inc()
+		return
+	}
+	pollTotal.WithLabelValues(account, "success").Inc()
+	lastSuccessfulPoll.WithLabelValues(account).Set(float64(time.Now().Unix()))
+}
+
+// instrumentedTransport observes the latency and status code of each request.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	apiRequestDuration.WithLabelValues(req.URL.Path, code).Observe(time.Since(start).Seconds())
+	return res, err
+}
+
+// InstrumentTransport wraps next, exporting the latency and status code of each
+// request to the Ecobee API on /metrics. Use with ecobee.Client.WrapTransport.
+// If next refreshes the access token before a request, the refresh is included
+// in the latency of that request.
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport{next: next}
+}
@@ -0,0 +1,62 @@
+package promobee
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatalf("failed writing metric: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestAccumulator_poll_instrumented(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	a.client.WrapTransport(InstrumentTransport)
+
+	successes := counterValue(t, pollTotal.WithLabelValues("", "success"))
+	failures := counterValue(t, pollTotal.WithLabelValues("", "error"))
+	summaries := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostatSummary", "200"))
+	fetches := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostat", "200"))
+
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	if got := counterValue(t, pollTotal.WithLabelValues("", "success")) - successes; got != 1 {
+		t.Errorf("poll_total{result=success}: increased by %v, want 1", got)
+	}
+	if got := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostatSummary", "200")) - summaries; got != 1 {
+		t.Errorf("api_request_duration_seconds for summary: got %d observations, want 1", got)
+	}
+	if got := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostat", "200")) - fetches; got != 1 {
+		t.Errorf("api_request_duration_seconds for thermostats: got %d observations, want 1", got)
+	}
+	if got := gaugeValue(t, lastSuccessfulPoll.WithLabelValues("")); got == 0 {
+		t.Errorf("last_successful_poll_timestamp_seconds: got 0, want the time of the poll")
+	}
+	if got := gaugeValue(t, tokenValidSeconds.WithLabelValues("")); got <= 0 {
+		t.Errorf("token_valid_seconds: got %v, want positive", got)
+	}
+
+	srv.Config.Handler = http.NotFoundHandler()
+	notFound := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostatSummary", "404"))
+	if err := a.poll(context.Background()); err == nil {
+		t.Fatalf("poll(): want error from failing API, got nil")
+	}
+	if got := counterValue(t, pollTotal.WithLabelValues("", "error")) - failures; got != 1 {
+		t.Errorf("poll_total{result=error}: increased by %v, want 1", got)
+	}
+	if got := histogramCount(t, apiRequestDuration.WithLabelValues("/1/thermostatSummary", "404")) - notFound; got != 1 {
+		t.Errorf("api_request_duration_seconds for failed summary: got %d observations, want 1", got)
+	}
+}
@@ -0,0 +1,138 @@
+package promobee
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// participationMetrics describe which sensors take part in each climate, and
+// the temperature of those taking part now, which is what the thermostat
+// actually maintains. A room may be cold simply because its sensor is not in
+// the running climate.
+type participationMetrics struct {
+	sensorInClimate *prometheus.GaugeVec
+	setpointOffset  *prometheus.GaugeVec
+	// The following have no labels, and are vectors so that nothing is exported
+	// until they are set.
+	participatingSensors     *prometheus.GaugeVec
+	participatingTemperature *prometheus.GaugeVec
+
+	unit Unit
+}
+
+// newParticipationMetrics which export temperatures in unit, and identify
+// sensors by sensorLabel.
+func newParticipationMetrics(unit Unit, sensorLabel string) *participationMetrics {
+	return &participationMetrics{
+		sensorInClimate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sensor_in_climate",
+				Help: "Sensors which take part in each climate of an Ecobee thermostat are emitted with a '1' metric, and others with '0'.",
+			},
+			[]string{sensorLabel, "climate"}),
+		participatingSensors: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "participating_sensors",
+				Help: "Number of sensors in use by an Ecobee thermostat which report a temperature.",
+			},
+			nil),
+		participatingTemperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("participating_temperature_%v", unit),
+				Help: fmt.Sprintf("Average temperature in %v of the sensors in use by an Ecobee thermostat, which it maintains at its setpoints.", unit.title()),
+			},
+			nil),
+		setpointOffset: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("participating_temperature_setpoint_offset_%v", unit),
+				Help: fmt.Sprintf("Degrees %v by which the average temperature of the sensors in use by an Ecobee thermostat is above each setpoint.", unit.title()),
+			},
+			[]string{"type"}),
+		unit: unit,
+	}
+}
+
+func (m *participationMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.sensorInClimate, m.participatingSensors, m.participatingTemperature, m.setpointOffset}
+}
+
+// exportClimates exports the sensors selected by sensors which take part in
+// each climate of p, and those of remote which do not.
+func (m *participationMetrics) exportClimates(p *egobee.Program, remote []egobee.RemoteSensor, sensors *Sensors) {
+	// Climates list the capabilities of each sensor, which are identified by
+	// the identifier of the sensor and that of the capability.
+	var all []egobee.RemoteSensor
+	seen := make(map[string]bool)
+	add := func(sensor egobee.RemoteSensor) {
+		sensor.ID = climateSensorID(sensor.ID)
+		if !seen[sensor.ID] {
+			seen[sensor.ID] = true
+			all = append(all, sensor)
+		}
+	}
+	for _, sensor := range remote {
+		add(sensor)
+	}
+	for _, c := range p.Climates {
+		for _, sensor := range c.Sensors {
+			add(sensor)
+		}
+	}
+	for _, c := range p.Climates {
+		in := make(map[string]bool, len(c.Sensors))
+		for _, sensor := range c.Sensors {
+			in[climateSensorID(sensor.ID)] = true
+		}
+		for i := range all {
+			id, ok := sensors.identify(&all[i])
+			if !ok {
+				continue
+			}
+			v := 0.0
+			if in[all[i].ID] {
+				v = 1
+			}
+			m.sensorInClimate.WithLabelValues(id, c.Name).Set(v)
+		}
+	}
+}
+
+// exportTemperature exports the average temperature of the sensors of remote
+// which are in use, whether or not they are exported, and its offset from the
+// setpoints of r if it is not nil. Sensors which do not report a temperature,
+// such as those which are offline, are left out of the average.
+func (m *participationMetrics) exportTemperature(remote []egobee.RemoteSensor, r *egobee.Runtime) {
+	var sum float64
+	var n int
+	for i := range remote {
+		if !remote[i].InUse {
+			continue
+		}
+		t, err := remote[i].Temperature()
+		if err != nil {
+			continue
+		}
+		sum += t
+		n++
+	}
+	m.participatingSensors.WithLabelValues().Set(float64(n))
+	if n < 1 {
+		return
+	}
+	average := m.unit.fromFahrenheit(sum / float64(n))
+	m.participatingTemperature.WithLabelValues().Set(hundredths(average))
+	if r == nil {
+		return
+	}
+	m.setpointOffset.WithLabelValues("heat").Set(hundredths(average - m.unit.fromFahrenheit(float64(r.DesiredHeat)/10)))
+	m.setpointOffset.WithLabelValues("cool").Set(hundredths(average - m.unit.fromFahrenheit(float64(r.DesiredCool)/10)))
+}
+
+// hundredths rounds f, so that averages and differences of temperatures do not
+// export floating point noise.
+func hundredths(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,113 @@
+package promobee
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func testSensor(id, name, temperature string, inUse bool) egobee.RemoteSensor {
+	return egobee.RemoteSensor{
+		ID:    id,
+		Name:  name,
+		InUse: inUse,
+		Capability: []egobee.RemoteSensorCapability{
+			{Type: egobee.CapabilityTypeTemperature, Value: temperature},
+		},
+	}
+}
+
+func TestParticipationMetrics_exportClimates(t *testing.T) {
+	// Climates list the capabilities of sensors, rather than the sensors.
+	p := &egobee.Program{Climates: []egobee.Climate{
+		{Name: "Home", Sensors: []egobee.RemoteSensor{{ID: "ei:0:1", Name: "Thermostat"}, {ID: "rs:101:1", Name: "Bedroom"}}},
+		{Name: "Sleep", Sensors: []egobee.RemoteSensor{{ID: "ei:0:1", Name: "Thermostat"}}},
+	}}
+	remote := []egobee.RemoteSensor{
+		testSensor("ei:0", "Thermostat", "700", true),
+		testSensor("rs:101", "Bedroom", "650", true),
+		testSensor("rs:102", "Garage", "550", false),
+	}
+	sensors := &Sensors{
+		Exclude:   []*regexp.Regexp{regexp.MustCompile("^Garage$")},
+		Locations: map[string]string{"Bedroom": "Main Bedroom"},
+	}
+	for _, tt := range []struct {
+		identity SensorIdentity
+		want     map[[2]string]float64
+	}{
+		{
+			identity: SensorIdentityLocation,
+			want: map[[2]string]float64{
+				{"Thermostat", "Home"}:   1,
+				{"Main Bedroom", "Home"}: 1,
+				{"Thermostat", "Sleep"}:  1,
+				// The bedroom is cold at night because its sensor is not in Sleep.
+				{"Main Bedroom", "Sleep"}: 0,
+			},
+		},
+		{
+			identity: SensorIdentityID,
+			want: map[[2]string]float64{
+				{"ei:0", "Home"}:    1,
+				{"rs:101", "Home"}:  1,
+				{"ei:0", "Sleep"}:   1,
+				{"rs:101", "Sleep"}: 0,
+			},
+		},
+	} {
+		sensors.Identity = tt.identity
+		m := newParticipationMetrics(UnitFahrenheit, sensors.label())
+		m.exportClimates(p, remote, sensors)
+		for labels, want := range tt.want {
+			if got := gaugeValue(t, m.sensorInClimate.WithLabelValues(labels[0], labels[1])); got != want {
+				t.Errorf("%v: sensor_in_climate{%v, %v}: got %v, want %v", tt.identity, labels[0], labels[1], got, want)
+			}
+		}
+		if got := seriesCount(t, m.sensorInClimate); got != len(tt.want) {
+			t.Errorf("%v: sensor_in_climate: got %d series, want %d without the excluded Garage", tt.identity, got, len(tt.want))
+		}
+	}
+}
+
+func TestParticipationMetrics_exportTemperature(t *testing.T) {
+	remote := []egobee.RemoteSensor{
+		testSensor("ei:0", "Thermostat", "702", true),
+		testSensor("rs:101", "Bedroom", "651", true),
+		// Offline sensors are left out, even if in use.
+		testSensor("rs:102", "Guest Room", "unknown", true),
+		testSensor("rs:103", "Garage", "550", false),
+	}
+	r := &egobee.Runtime{DesiredHeat: 680, DesiredCool: 740}
+
+	m := newParticipationMetrics(UnitFahrenheit, "location")
+	m.exportTemperature(remote, r)
+	if got := gaugeValue(t, m.participatingSensors.WithLabelValues()); got != 2 {
+		t.Errorf("participating_sensors: got %v, want 2", got)
+	}
+	if got := gaugeValue(t, m.participatingTemperature.WithLabelValues()); got != 67.65 {
+		t.Errorf("participating_temperature_fahrenheit: got %v, want 67.65", got)
+	}
+	if got := gaugeValue(t, m.setpointOffset.WithLabelValues("heat")); got != -0.35 {
+		t.Errorf("participating_temperature_setpoint_offset_fahrenheit{heat}: got %v, want -0.35", got)
+	}
+	if got := gaugeValue(t, m.setpointOffset.WithLabelValues("cool")); got != -6.35 {
+		t.Errorf("participating_temperature_setpoint_offset_fahrenheit{cool}: got %v, want -6.35", got)
+	}
+
+	m = newParticipationMetrics(UnitCelsius, "location")
+	m.exportTemperature(remote, nil)
+	if got := gaugeValue(t, m.participatingTemperature.WithLabelValues()); got != 19.81 {
+		t.Errorf("participating_temperature_celsius: got %v, want 19.81", got)
+	}
+	if got := seriesCount(t, m.setpointOffset); got != 0 {
+		t.Errorf("participating_temperature_setpoint_offset_celsius: got %d series without the runtime, want 0", got)
+	}
+
+	m = newParticipationMetrics(UnitFahrenheit, "location")
+	m.exportTemperature(remote[2:], r)
+	if got := seriesCount(t, m.participatingTemperature); got != 0 {
+		t.Errorf("participating_temperature_fahrenheit: got %d series without a temperature, want 0", got)
+	}
+}
@@ -0,0 +1,170 @@
+package promobee
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// programMetrics are exported from the program of a thermostat: the climates,
+// such as Home, Away and Sleep, between which its schedule switches.
+type programMetrics struct {
+	currentClimate     *prometheus.GaugeVec
+	climateTemperature *prometheus.GaugeVec
+	climateOccupied    *prometheus.GaugeVec
+
+	unit Unit
+}
+
+// newProgramMetrics which export temperatures in unit.
+func newProgramMetrics(unit Unit) *programMetrics {
+	return &programMetrics{
+		currentClimate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "current_climate",
+				Help: "Climate which the program of an Ecobee thermostat is currently running is emitted with a '1' metric.",
+			},
+			[]string{"climate_ref", "name"}),
+		climateTemperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("climate_temperature_%v", unit),
+				Help: fmt.Sprintf("Setpoints in %v of each climate in the program of an Ecobee thermostat.", unit.title()),
+			},
+			[]string{"climate_ref", "name", "type"}),
+		climateOccupied: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "climate_occupied",
+				Help: "Whether each climate in the program of an Ecobee thermostat is for when the building is occupied.",
+			},
+			[]string{"climate_ref", "name"}),
+		unit: unit,
+	}
+}
+
+func (m *programMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.currentClimate, m.climateTemperature, m.climateOccupied}
+}
+
+// export the metrics from p.
+func (m *programMetrics) export(p *egobee.Program) {
+	for _, c := range p.Climates {
+		m.climateTemperature.WithLabelValues(c.ClimateRef, c.Name, "heat").Set(m.unit.fromFahrenheit(float64(c.HeatTemp) / 10))
+		m.climateTemperature.WithLabelValues(c.ClimateRef, c.Name, "cool").Set(m.unit.fromFahrenheit(float64(c.CoolTemp) / 10))
+		occupied := 0.0
+		if c.IsOccupied {
+			occupied = 1
+		}
+		m.climateOccupied.WithLabelValues(c.ClimateRef, c.Name).Set(occupied)
+	}
+	if p.CurrentClimateRef != "" {
+		m.currentClimate.WithLabelValues(p.CurrentClimateRef, climateName(p, p.CurrentClimateRef)).Set(1)
+	}
+}
+
+// climateName of the climate of p identified by ref, or ref itself if there is
+// no such climate.
+func climateName(p *egobee.Program, ref string) string {
+	for _, c := range p.Climates {
+		if c.ClimateRef == ref {
+			return c.Name
+		}
+	}
+	return ref
+}
+
+// scheduleDays names the days of the program schedule, which begins on Monday.
+var scheduleDays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// slotLength is the length in minutes of each slot of a schedule day.
+const slotLength = 30
+
+// scheduleSlot is a half hour of a schedule day, in thermostat local time.
+type scheduleSlot struct {
+	Start      string `json:"start"`
+	ClimateRef string `json:"climateRef"`
+	Name       string `json:"name"`
+}
+
+type scheduleDay struct {
+	Day   string          `json:"day"`
+	Slots []*scheduleSlot `json:"slots"`
+}
+
+// scheduleClimate is a climate of the program, with setpoints in the unit of
+// the metrics.
+type scheduleClimate struct {
+	ClimateRef string  `json:"climateRef"`
+	Name       string  `json:"name"`
+	Occupied   bool    `json:"occupied"`
+	Heat       float64 `json:"heat"`
+	Cool       float64 `json:"cool"`
+	Unit       Unit    `json:"unit"`
+	Current    bool    `json:"current"`
+}
+
+// schedule of a thermostat, served as JSON by ServeSchedule.
+type schedule struct {
+	CurrentClimateRef string             `json:"currentClimateRef"`
+	Climates          []*scheduleClimate `json:"climates"`
+	Days              []*scheduleDay     `json:"days"`
+}
+
+// newSchedule renders p, resolving the name of the climate of each slot and
+// converting setpoints to unit.
+func newSchedule(p *egobee.Program, unit Unit) *schedule {
+	s := &schedule{CurrentClimateRef: p.CurrentClimateRef}
+	for _, c := range p.Climates {
+		s.Climates = append(s.Climates, &scheduleClimate{
+			ClimateRef: c.ClimateRef,
+			Name:       c.Name,
+			Occupied:   c.IsOccupied,
+			Heat:       unit.fromFahrenheit(float64(c.HeatTemp) / 10),
+			Cool:       unit.fromFahrenheit(float64(c.CoolTemp) / 10),
+			Unit:       unit,
+			Current:    c.ClimateRef == p.CurrentClimateRef,
+		})
+	}
+	for i, refs := range p.Schedule {
+		day := &scheduleDay{Day: fmt.Sprintf("day%d", i)}
+		if i < len(scheduleDays) {
+			day.Day = scheduleDays[i]
+		}
+		for j, ref := range refs {
+			minutes := j * slotLength
+			day.Slots = append(day.Slots, &scheduleSlot{
+				Start:      fmt.Sprintf("%02d:%02d", minutes/60, minutes%60),
+				ClimateRef: ref,
+				Name:       climateName(p, ref),
+			})
+		}
+		s.Days = append(s.Days, day)
+	}
+	return s
+}
+
+// parseSchedulePath returns the identifier of the thermostat in a request for
+// /thermostat/{id}/schedule, or responds with an error.
+func parseSchedulePath(w http.ResponseWriter, req *http.Request) (string, bool) {
+	id, action, ok := parseControlPath(req.URL.Path)
+	if !ok || action != "schedule" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return "", false
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	return id, true
+}
+
+// serveSchedule of the thermostat as JSON.
+func (m *thermostatMetrics) serveSchedule(w http.ResponseWriter) {
+	if m.schedule == nil {
+		http.Error(w, "no schedule for the thermostat; is the program included?", http.StatusNotFound)
+		return
+	}
+	serveJSON(w, http.StatusOK, m.schedule)
+}
@@ -0,0 +1,130 @@
+package promobee
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// testProgram switches from sleep to home at 06:30 every day.
+func testProgram() *egobee.Program {
+	p := &egobee.Program{
+		Climates: []egobee.Climate{
+			{Name: "Home", ClimateRef: "home", IsOccupied: true, HeatTemp: 680, CoolTemp: 770},
+			{Name: "Sleep", ClimateRef: "sleep", IsOccupied: true, HeatTemp: 620, CoolTemp: 800},
+			{Name: "Away", ClimateRef: "away", HeatTemp: 600, CoolTemp: 850},
+		},
+		CurrentClimateRef: "home",
+	}
+	for day := 0; day < 7; day++ {
+		slots := make([]string, 48)
+		for i := range slots {
+			slots[i] = "home"
+			if i < 13 {
+				slots[i] = "sleep"
+			}
+		}
+		p.Schedule = append(p.Schedule, slots)
+	}
+	return p
+}
+
+func TestProgramMetrics_export(t *testing.T) {
+	m := newProgramMetrics(UnitCelsius)
+	m.export(testProgram())
+
+	if got := gaugeValue(t, m.currentClimate.WithLabelValues("home", "Home")); got != 1 {
+		t.Errorf("current_climate{home}: got %v, want 1", got)
+	}
+	if got := seriesCount(t, m.currentClimate); got != 1 {
+		t.Errorf("current_climate: got %d series, want 1", got)
+	}
+	if got := gaugeValue(t, m.climateTemperature.WithLabelValues("home", "Home", "heat")); got != 20 {
+		t.Errorf("climate_temperature_celsius{home, heat}: got %v, want 20", got)
+	}
+	if got := gaugeValue(t, m.climateTemperature.WithLabelValues("away", "Away", "cool")); got != 29.44 {
+		t.Errorf("climate_temperature_celsius{away, cool}: got %v, want 29.44", got)
+	}
+	if got := gaugeValue(t, m.climateOccupied.WithLabelValues("sleep", "Sleep")); got != 1 {
+		t.Errorf("climate_occupied{sleep}: got %v, want 1", got)
+	}
+	if got := gaugeValue(t, m.climateOccupied.WithLabelValues("away", "Away")); got != 0 {
+		t.Errorf("climate_occupied{away}: got %v, want 0", got)
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	p := testProgram()
+	// A climate which is not in the program is named by its reference.
+	p.Schedule[6][47] = "smart1"
+	s := newSchedule(p, UnitFahrenheit)
+
+	if len(s.Days) != 7 {
+		t.Fatalf("newSchedule(): got %d days, want 7", len(s.Days))
+	}
+	for _, test := range []struct {
+		day, slot                    int
+		wantDay, wantStart, wantName string
+	}{
+		{day: 0, slot: 0, wantDay: "monday", wantStart: "00:00", wantName: "Sleep"},
+		{day: 0, slot: 12, wantDay: "monday", wantStart: "06:00", wantName: "Sleep"},
+		{day: 0, slot: 13, wantDay: "monday", wantStart: "06:30", wantName: "Home"},
+		{day: 6, slot: 47, wantDay: "sunday", wantStart: "23:30", wantName: "smart1"},
+	} {
+		d := s.Days[test.day]
+		if d.Day != test.wantDay {
+			t.Errorf("newSchedule(): got day %d %q, want %q", test.day, d.Day, test.wantDay)
+		}
+		if len(d.Slots) != 48 {
+			t.Fatalf("newSchedule(): got %d slots on %v, want 48", len(d.Slots), d.Day)
+		}
+		if got := d.Slots[test.slot]; got.Start != test.wantStart || got.Name != test.wantName {
+			t.Errorf("newSchedule(): got %v slot %d %+v, want start %v and name %v", d.Day, test.slot, got, test.wantStart, test.wantName)
+		}
+	}
+	if len(s.Climates) != 3 || !s.Climates[0].Current || s.Climates[1].Current || s.Climates[0].Heat != 68 {
+		t.Errorf("newSchedule(): got climates %+v, want Home current with heat 68", s.Climates)
+	}
+}
+
+func TestAccumulator_ServeSchedule(t *testing.T) {
+	m := newThermostatMetrics(UnitFahrenheit, nil, nil)
+	m.schedule = newSchedule(testProgram(), UnitFahrenheit)
+	a := &Accumulator{}
+	a.snapshot.Store(&snapshot{thermostats: map[string]*thermostatMetrics{
+		"123": m,
+		"456": newThermostatMetrics(UnitFahrenheit, nil, nil),
+	}})
+
+	for _, test := range []struct {
+		name, method, path string
+		want               int
+	}{
+		{name: "schedule", method: http.MethodGet, path: "/thermostat/123/schedule", want: http.StatusOK},
+		{name: "program not fetched", method: http.MethodGet, path: "/thermostat/456/schedule", want: http.StatusNotFound},
+		{name: "no thermostat", method: http.MethodGet, path: "/thermostat/789/schedule", want: http.StatusNotFound},
+		{name: "other action", method: http.MethodGet, path: "/thermostat/123/hold", want: http.StatusNotFound},
+		{name: "post", method: http.MethodPost, path: "/thermostat/123/schedule", want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			a.ServeSchedule(rr, httptest.NewRequest(test.method, test.path, nil))
+			if rr.Code != test.want {
+				t.Fatalf("ServeSchedule(%v %v): got status %v, want %v", test.method, test.path, rr.Code, test.want)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			got := &schedule{}
+			if err := json.Unmarshal(rr.Body.Bytes(), got); err != nil {
+				t.Fatalf("ServeSchedule(%v %v): invalid JSON: %v", test.method, test.path, err)
+			}
+			if got.CurrentClimateRef != "home" || len(got.Days) != 7 || got.Days[2].Slots[20].Name != "Home" {
+				t.Errorf("ServeSchedule(%v %v): got %+v, want Home current and at 10:00 on wednesday", test.method, test.path, got)
+			}
+		})
+	}
+}
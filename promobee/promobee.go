@@ -1,19 +1,28 @@
 package promobee
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cfunkhouser/egobee"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/cfunkhouser/promobee/ecobee"
 )
 
+// thermostatMetrics exported for a single thermostat. They are built from a
+// thermostatState by each poll, and never modified once published in a
+// snapshot.
 type thermostatMetrics struct {
 	tempMetric      *prometheus.GaugeVec
 	hvacModeMetric  *prometheus.GaugeVec
@@ -21,20 +30,51 @@ type thermostatMetrics struct {
 	hvacInOperation *prometheus.GaugeVec
 	humidityMetric  *prometheus.GaugeVec
 	occupancyMetric *prometheus.GaugeVec
+	sensorInUse     *prometheus.GaugeVec
+	sensorInfo      *prometheus.GaugeVec
+	runtimeMetric   *prometheus.CounterVec
+
+	revisionChangeMetric *prometheus.GaugeVec
+	alertActiveMetric    *prometheus.GaugeVec
+	alertInfoMetric      *prometheus.GaugeVec
+	weather              *weatherMetrics
+	runtime              *runtimeMetrics
+	program              *programMetrics
+	participation        *participationMetrics
+	capabilities         *capabilityMetrics
+
+	// unit in which temperatures are exported.
+	unit Unit
+	// setpoints accepted by the thermostat, if its settings are fetched.
+	setpoints *setpointRange
+	// sensorLabel identifies each sensor in its metrics.
+	sensorLabel string
+
+	// registry of all of the above.
+	registry *prometheus.Registry
+
+	// name of the thermostat, as reported in the thermostat summary.
+	name string
+	// schedule of the thermostat, if the program is fetched.
+	schedule *schedule
 }
 
-func newThermostatMetrics() *thermostatMetrics {
-	return &thermostatMetrics{
+// newThermostatMetrics which export temperatures in unit. The names of the
+// temperature metric families include the unit. The metrics of each sensor are
+// labeled with the identity configured by sensors.
+func newThermostatMetrics(unit Unit, labels prometheus.Labels, sensors *Sensors) *thermostatMetrics {
+	sensorLabel := sensors.label()
+	m := &thermostatMetrics{
 		tempMetric: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "temperature_fahrenheit",
-				Help: "Temperature in Fahrenheit as reported by an Ecobee sensor.",
+				Name: fmt.Sprintf("temperature_%v", unit),
+				Help: fmt.Sprintf("Temperature in %v as reported by an Ecobee sensor.", unit.title()),
 			},
-			[]string{"location"}),
+			[]string{sensorLabel}),
 		holdTempMetric: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "hold_temperature_fahrenheit",
-				Help: "Hold temperatures in Fahrenheit as reported by an Ecobee Thermostat",
+				Name: fmt.Sprintf("hold_temperature_%v", unit),
+				Help: fmt.Sprintf("Hold temperatures in %v as reported by an Ecobee Thermostat", unit.title()),
 			},
 			[]string{"type"},
 		),
@@ -57,133 +97,436 @@ func newThermostatMetrics() *thermostatMetrics {
 				Name: "humidity",
 				Help: "Humidity as reported by an Ecobee sensor.",
 			},
-			[]string{"location"}),
+			[]string{sensorLabel}),
 
 		occupancyMetric: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "occupancy",
 				Help: "Occupancy as reported by an Ecobee sensor.",
 			},
-			[]string{"location"}),
+			[]string{sensorLabel}),
+
+		sensorInUse: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sensor_in_use",
+				Help: "Sensors whose temperature is averaged by an Ecobee thermostat in its running climate are emitted with a '1' metric, and others with '0'.",
+			},
+			[]string{sensorLabel}),
+
+		sensorInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sensor_info",
+				Help: "Identity of an Ecobee sensor, whichever label identifies it in its other metrics. Always '1'.",
+			},
+			[]string{"location", "sensor_id", "sensor_type", "code"}),
+
+		runtimeMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "equipment_runtime_seconds_total",
+				Help: "Total seconds HVAC equipment has run, as reported in 5 minute intervals by an Ecobee thermostat.",
+			},
+			[]string{"equipment"}),
+
+		revisionChangeMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "last_revision_change_timestamp_seconds",
+				Help: "Time at which a new revision of a section of Ecobee thermostat data was observed.",
+			},
+			[]string{"section"}),
+
+		alertActiveMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "alert_active",
+				Help: "Alerts which have not been acknowledged on an Ecobee thermostat are emitted with a '1' metric.",
+			},
+			[]string{"alert_number", "alert_type", "severity"}),
+
+		alertInfoMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "alert_info",
+				Help: "Details of an alert on an Ecobee thermostat. Always '1'.",
+			},
+			[]string{"alert_number", "acknowledge_ref", "text"}),
+
+		weather:       newWeatherMetrics(unit),
+		runtime:       newRuntimeMetrics(unit),
+		program:       newProgramMetrics(unit),
+		participation: newParticipationMetrics(unit, sensorLabel),
+		capabilities:  newCapabilityMetrics(sensorLabel),
+		unit:          unit,
+		sensorLabel:   sensorLabel,
 	}
+	m.registry = prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(labels, m.registry).MustRegister(m.collectors()...)
+	return m
 }
 
-var thermostatSelection = &egobee.Selection{
-	SelectionType:   egobee.SelectionTypeRegistered,
-	IncludeDevice:   true,
-	IncludeEvents:   true,
-	IncludeRuntime:  true,
-	IncludeSensors:  true,
-	IncludeSettings: true,
+var labelNameRx = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// CheckLabels returns an error if labels cannot be added to the metrics of every
+// thermostat, because they are invalid or conflict with labels already in use.
+func CheckLabels(labels map[string]string) error {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m := newThermostatMetrics(UnitFahrenheit, nil, nil)
+	for _, name := range names {
+		switch {
+		case !labelNameRx.MatchString(name) || strings.HasPrefix(name, "__"):
+			return fmt.Errorf("%q is not a valid label name", name)
+		case name == thermostatIDLabel || name == thermostatNameLabel:
+			return fmt.Errorf("label %q is reserved for the thermostat", name)
+		}
+		r := prometheus.WrapRegistererWith(prometheus.Labels{name: labels[name]}, prometheus.NewRegistry())
+		for _, c := range m.collectors() {
+			if err := r.Register(c); err != nil {
+				return fmt.Errorf("label %q is already used by thermostat metrics", name)
+			}
+		}
+	}
+	return nil
 }
 
-// Accumulator of Ecobee information for reexport.
-type Accumulator struct {
-	client *egobee.Client
-	done   chan<- bool
+// serve the metrics in Prometheus exposition format.
+func (m *thermostatMetrics) serve(w http.ResponseWriter, req *http.Request) {
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+}
 
-	mu          sync.RWMutex // protects following members
-	thermostats map[string]*thermostatMetrics
+func (m *thermostatMetrics) collectors() []prometheus.Collector {
+	c := []prometheus.Collector{m.tempMetric, m.occupancyMetric, m.sensorInUse, m.sensorInfo, m.humidityMetric, m.holdTempMetric, m.hvacInOperation, m.hvacModeMetric, m.runtimeMetric, m.revisionChangeMetric, m.alertActiveMetric, m.alertInfoMetric}
+	c = append(c, m.runtime.collectors()...)
+	c = append(c, m.program.collectors()...)
+	c = append(c, m.participation.collectors()...)
+	c = append(c, m.capabilities.collectors()...)
+	return append(c, m.weather.collectors()...)
 }
 
-func (a *Accumulator) metricsForThermostatIdentifier(identifier *string) *thermostatMetrics {
-	a.mu.RLock()
-	t, ok := a.thermostats[*identifier]
-	a.mu.RUnlock()
+// exportThermostat exports the metrics derived from the thermostat section.
+// Hold temperatures are only exported if events are.
+func (m *thermostatMetrics) exportThermostat(thermostat *ecobee.Thermostat, events bool) {
+	m.holdTempMetric.Reset()
 
-	if !ok {
-		t = newThermostatMetrics()
-		a.mu.Lock()
-		a.thermostats[*identifier] = t
-		a.mu.Unlock()
+	if events && thermostat.Settings.HVACMode != "off" {
+		for _, event := range thermostat.Events {
+			if event.Running && event.Type == "hold" {
+				if !event.IsCoolOff && thermostat.Settings.HVACMode != "heat" {
+					m.holdTempMetric.WithLabelValues("cool").Set(m.unit.fromFahrenheit(float64(event.CoolHoldTemp) / 10))
+				}
+				if !event.IsHeatOff && thermostat.Settings.HVACMode != "cool" {
+					m.holdTempMetric.WithLabelValues("heat").Set(m.unit.fromFahrenheit(float64(event.HeatHoldTemp) / 10))
+				}
+			}
+		}
 	}
 
-	return t
+	m.hvacModeMetric.Reset()
+	m.hvacModeMetric.WithLabelValues(thermostat.Settings.HVACMode).Set(1)
 }
 
-func (a *Accumulator) poll() error {
-	thermostats, err := a.client.Thermostats(thermostatSelection)
-	if err != nil {
-		return err // This error is unrecoverable.
-	}
-	if len(thermostats) < 1 {
-		log.Printf("Payload contained no thermostats.")
-		// Not technically an error. Just inconvenient.
-		return nil
+// exportAlerts exports the metrics derived from the alerts section.
+func (m *thermostatMetrics) exportAlerts(thermostat *ecobee.Thermostat) {
+	m.alertActiveMetric.Reset()
+	m.alertInfoMetric.Reset()
+	for _, alert := range thermostat.Alerts {
+		number := strconv.Itoa(alert.AlertNumber)
+		m.alertActiveMetric.WithLabelValues(number, alert.AlertType, alert.Severity).Set(1)
+		m.alertInfoMetric.WithLabelValues(number, alert.AcknowledgeRef, alert.Text).Set(1)
 	}
-	for _, thermostat := range thermostats {
-		if len(thermostat.RemoteSensors) < 1 {
-			log.Printf("Thermostat has no sensors.")
+}
+
+// exportSensors exports the metrics reported by each sensor selected by sensors.
+func (m *thermostatMetrics) exportSensors(thermostat *ecobee.Thermostat, sensors *Sensors) {
+	for _, sensor := range thermostat.RemoteSensors {
+		location, ok := sensors.location(sensor.Name)
+		if !ok {
 			continue
 		}
-		m := a.metricsForThermostatIdentifier(&thermostat.Identifier)
-
-		m.holdTempMetric.Reset()
+		m.sensorInfo.WithLabelValues(location, sensor.ID, sensor.Type, sensor.Code).Set(1)
+		id, _ := sensors.identify(&sensor)
+		labels := prometheus.Labels{m.sensorLabel: id}
+		m.capabilities.export(id, &sensor, sensors)
+
+		h, err := sensor.Humidity()
+		// Only handle the successful case; if the sensor doesn't have humidity, that isn't fatal
+		if err == nil {
+			m.humidityMetric.With(labels).Set(float64(h))
+		}
 
-		if thermostat.Settings.HVACMode != "off" {
-			for _, event := range thermostat.Events {
-				if event.Running && event.Type == "hold" {
-					if !event.IsCoolOff && thermostat.Settings.HVACMode != "heat" {
-						m.holdTempMetric.WithLabelValues("cool").Set(float64(event.CoolHoldTemp) / 10)
-					}
-					if !event.IsHeatOff && thermostat.Settings.HVACMode != "cool" {
-						m.holdTempMetric.WithLabelValues("heat").Set(float64(event.HeatHoldTemp) / 10)
-					}
-				}
+		o, err := sensor.Occupancy()
+		// Only handle the successful case; if the sensor doesn't have occupancy, that isn't fatal
+		if err == nil {
+			v := 0.0
+			if o {
+				v = 1.0
 			}
+			m.occupancyMetric.With(labels).Set(v)
 		}
 
-		m.hvacModeMetric.Reset()
-		m.hvacModeMetric.WithLabelValues(thermostat.Settings.HVACMode).Set(1)
+		inUse := 0.0
+		if sensor.InUse {
+			inUse = 1
+		}
+		m.sensorInUse.With(labels).Set(inUse)
 
-		for _, sensor := range thermostat.RemoteSensors {
-			h, err := sensor.Humidity()
-			// Only handle the successful case; if the sensor doesn't have humidity, that isn't fatal
-			if err == nil {
-				m.humidityMetric.With(prometheus.Labels{"location": sensor.Name}).Set(float64(h))
-			}
+		t, err := sensor.Temperature()
+		if err != nil {
+			// We may still be able to get useful information from the payload,
+			// so skip this error.
+			log.Printf("Error getting temperature from %q: %v", sensor.Name, err)
+			continue
+		}
+		m.tempMetric.With(labels).Set(m.unit.fromFahrenheit(t))
+	}
+}
 
-			o, err := sensor.Occupancy()
-			// Only handle the successful case; if the sensor doesn't have occupancy, that isn't fatal
-			if err == nil {
-				v := 0.0
-				if o {
-					v = 1.0
-				}
-				m.occupancyMetric.With(prometheus.Labels{"location": sensor.Name}).Set(v)
-			}
+// maxThermostatsPerSelection is the limit the API imposes on the number of
+// identifiers in a SelectionTypeThermostats match.
+const maxThermostatsPerSelection = 25
 
-			t, err := sensor.Temperature()
-			if err != nil {
-				// We may still be able to get useful information from the payload,
-				// so skip this error.
-				log.Printf("Error getting temperature from %q: %v", sensor.Name, err)
-				continue
-			}
-			m.tempMetric.With(prometheus.Labels{"location": sensor.Name}).Set(t)
+// Accumulator of Ecobee information for reexport.
+type Accumulator struct {
+	client *ecobee.Client
+
+	optsMu sync.RWMutex // protects opts, which is replaced but never modified
+	opts   *Opts
+	// reconfigured is signalled when opts is replaced.
+	reconfigured chan struct{}
+
+	// cancel the context of the poller, which closes stopped on exit.
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	pollMu sync.Mutex // serializes polls, and protects following members
+	states map[string]*thermostatState
+	// selection polled, which changes with opts once the poller applies them.
+	selection *Selection
+
+	// snapshot is the most recently published *snapshot.
+	snapshot atomic.Value
+
+	health health
+}
+
+// options of the Accumulator, which may be nil.
+func (a *Accumulator) options() *Opts {
+	a.optsMu.RLock()
+	defer a.optsMu.RUnlock()
+	return a.opts
+}
+
+// Reconfigure the Accumulator with o, which takes effect without waiting for
+// the next poll. The state of every thermostat is kept, so no counters are
+// reset. The account of the Accumulator cannot be changed.
+func (a *Accumulator) Reconfigure(o *Opts) {
+	next := *o
+	a.optsMu.Lock()
+	next.Account = a.opts.account()
+	a.opts = &next
+	a.optsMu.Unlock()
+	select {
+	case a.reconfigured <- struct{}{}:
+	default:
+		// The poller has yet to apply an earlier change, and will see this one.
+	}
+}
+
+// applyOptions to the state of every thermostat, and publish metrics built
+// with them. If the selection changed, every thermostat must be fetched again,
+// which applyOptions reports.
+func (a *Accumulator) applyOptions() (refetch bool) {
+	a.pollMu.Lock()
+	defer a.pollMu.Unlock()
+	o := a.options()
+	if sel := o.selection(); !reflect.DeepEqual(sel, a.selection) {
+		a.selection = sel
+		refetch = true
+	}
+	for id, s := range a.states {
+		s.setUnit(o.unitFor(id))
+		s.refetch = s.refetch || refetch
+	}
+	a.publish()
+	return refetch
+}
+
+// state of the thermostat identified by id, created if necessary.
+func (a *Accumulator) state(id string) *thermostatState {
+	s, ok := a.states[id]
+	if !ok {
+		s = newThermostatState(a.options().unitFor(id))
+		a.states[id] = s
+	}
+	return s
+}
+
+// unit in which the temperatures of the thermostat identified by id are
+// exported. Until the thermostat has been polled, UnitAuto is Fahrenheit.
+func (a *Accumulator) unit(id string) Unit {
+	if t, ok := a.current().thermostats[id]; ok {
+		return t.unit
+	}
+	return a.options().unitFor(id).initial()
+}
+
+// setpoints accepted by the thermostat identified by id, or nil unless its
+// settings have been polled.
+func (a *Accumulator) setpoints(id string) *setpointRange {
+	if t, ok := a.current().thermostats[id]; ok {
+		return t.setpoints
+	}
+	return nil
+}
+
+// current snapshot of metrics, which is never nil.
+func (a *Accumulator) current() *snapshot {
+	if s, ok := a.snapshot.Load().(*snapshot); ok {
+		return s
+	}
+	return &snapshot{}
+}
+
+// publish a new snapshot built from the state of every thermostat.
+func (a *Accumulator) publish() {
+	s := &snapshot{thermostats: make(map[string]*thermostatMetrics, len(a.states))}
+	for id, state := range a.states {
+		s.thermostats[id] = state.metrics(a.options())
+	}
+	a.snapshot.Store(s)
+}
+
+// fetch thermostats identified by ids, including only the data for sections
+// which is in includes, and update their state.
+func (a *Accumulator) fetch(ctx context.Context, ids []string, sections []section, includes map[Include]bool, revs map[string]*ecobee.Revision) error {
+	var fetched []section
+	for _, s := range sections {
+		if s.fetched(includes) {
+			fetched = append(fetched, s)
 		}
 	}
+	now := time.Now()
+	if len(fetched) < 1 {
+		for _, id := range ids {
+			a.state(id).updateRevision(revs[id], sections, now)
+		}
+		return nil
+	}
 
-	statSummary, err := a.client.ThermostatSummary()
-	if err != nil {
+	thermostats, pages, err := a.client.PagedThermostats(ctx, selectionFor(ids, fetched, includes))
+	pollPagesFetched.WithLabelValues(a.options().account()).Add(float64(pages))
+	// Thermostats from pages fetched before any error are still exported. Those
+	// missing will be retried on the next poll, since their revisions are not
+	// updated.
+	for _, thermostat := range thermostats {
+		rev, ok := revs[thermostat.Identifier]
+		if !ok {
+			log.Printf("Thermostat %q was not requested", thermostat.Identifier)
+			continue
+		}
+		s := a.state(thermostat.Identifier)
+		s.update(thermostat, fetched, includes)
+		s.updateRevision(rev, sections, now)
+	}
+	return err
+}
+
+// poll the API for changes to thermostats, and record the outcome.
+func (a *Accumulator) poll(ctx context.Context) error {
+	start := time.Now()
+	err := a.update(ctx)
+	if ctx.Err() != nil {
+		// Polls interrupted by Stop say nothing about the health of the API.
 		return err
 	}
+	account := a.options().account()
+	observePoll(account, start, err)
+	a.health.record(err, time.Now())
+	tokenValidSeconds.WithLabelValues(account).Set(a.client.TokenValidFor().Seconds())
+	return err
+}
+
+// update polls the API for changes to thermostats, and publishes a new snapshot
+// of their metrics. Thermostats which are no longer reported are dropped.
+func (a *Accumulator) update(ctx context.Context) error {
+	a.pollMu.Lock()
+	defer a.pollMu.Unlock()
+
+	pollPagesFetched.WithLabelValues(a.options().account()).Set(0)
+
+	statSummary, err := a.client.ThermostatSummary(ctx, a.selection.summary())
+	if err != nil {
+		return err // This error is unrecoverable.
+	}
 
+	equipment := make(map[string][]string)
 	for _, status := range statSummary.StatusList {
 		d := strings.Split(status, ":")
 		if len(d) != 2 {
 			log.Printf("Thermostat status '%s' did not have two fields", status)
 			continue
 		}
-		m := a.metricsForThermostatIdentifier(&d[0])
-		m.hvacInOperation.Reset()
 		if d[1] != "" {
-			for _, unit := range strings.Split(d[1], ",") {
-				m.hvacInOperation.WithLabelValues(unit).Set(1)
-			}
+			equipment[d[0]] = strings.Split(d[1], ",")
 		}
 	}
 
+	revisions, err := ecobee.Revisions(statSummary)
+	if err != nil {
+		return err
+	}
+	if len(revisions) < 1 {
+		log.Printf("Payload contained no thermostats.")
+		// Not technically an error. Just inconvenient.
+	}
+
+	// Group thermostats by the sections which have changed, so that each group
+	// can be fetched together.
+	revs := make(map[string]*ecobee.Revision)
+	groups := make(map[string][]string)
+	groupSections := make(map[string][]section)
+	for i := range revisions {
+		rev := &revisions[i]
+		revs[rev.Identifier] = rev
+		s := a.state(rev.Identifier)
+		s.name = rev.Name
+		s.equipment = equipment[rev.Identifier]
+		changed := changedSections(&s.revision, rev)
+		if s.refetch {
+			changed = allSections
+		}
+		if len(changed) < 1 {
+			continue
+		}
+		key := sectionsKey(changed)
+		groups[key] = append(groups[key], rev.Identifier)
+		groupSections[key] = changed
+	}
+	for id := range a.states {
+		if _, ok := revs[id]; !ok {
+			delete(a.states, id)
+		}
+	}
+
+	includes := a.selection.includes()
+	var errs []string
+	for key, ids := range groups {
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > maxThermostatsPerSelection {
+				n = maxThermostatsPerSelection
+			}
+			if err := a.fetch(ctx, ids[:n], groupSections[key], includes, revs); err != nil {
+				// Revisions are not updated, so these will be retried next poll.
+				errs = append(errs, err.Error())
+			}
+			ids = ids[n:]
+		}
+	}
+	a.publish()
+	if len(errs) > 0 {
+		return fmt.Errorf("failed fetching thermostats: %v", strings.Join(errs, "; "))
+	}
 	return nil
 }
 
@@ -192,20 +535,13 @@ func (a *Accumulator) poll() error {
 func (a *Accumulator) ServeThermostatsList(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
-	ids := make([]string, 0)
-	a.mu.RLock()
-	for id := range a.thermostats {
-		ids = append(ids, id)
-	}
-	a.mu.RUnlock()
-
-	sort.Strings(ids) // consistency!
-	for _, id := range ids {
+	for _, id := range a.current().ids() {
 		fmt.Fprintf(w, "%v\n", id)
 	}
 }
 
-// ServeThermostat is a http.HandlerFunc which serves the
+// ServeThermostat is a http.HandlerFunc which serves the metrics of the
+// thermostat identified by the id query parameter.
 func (a *Accumulator) ServeThermostat(w http.ResponseWriter, req *http.Request) {
 	id := req.URL.Query().Get("id")
 	if id == "" {
@@ -214,32 +550,36 @@ func (a *Accumulator) ServeThermostat(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-
-	t, ok := a.thermostats[id]
+	t, ok := a.current().thermostats[id]
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Not Found")
 		return
 	}
+	t.serve(w, req)
+}
 
-	registry := prometheus.NewRegistry()
-	metrics := []*prometheus.GaugeVec{t.tempMetric, t.occupancyMetric, t.humidityMetric, t.holdTempMetric, t.hvacInOperation, t.hvacModeMetric}
-	for _, m := range metrics {
-		if err := registry.Register(m); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Internal Server Error")
-			return
-		}
+// ServeSchedule is a http.HandlerFunc which serves the program schedule of the
+// thermostat identified by a path of the form /thermostat/{id}/schedule, as
+// JSON.
+func (a *Accumulator) ServeSchedule(w http.ResponseWriter, req *http.Request) {
+	id, ok := parseSchedulePath(w, req)
+	if !ok {
+		return
 	}
-
-	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+	t, ok := a.current().thermostats[id]
+	if !ok {
+		http.Error(w, errNoThermostat.Error(), http.StatusNotFound)
+		return
+	}
+	t.serveSchedule(w)
 }
 
-// Stop polling the Ecobee API.
+// Stop polling the Ecobee API, cancelling any request in progress, and wait for
+// the poller to exit. Stop may be called more than once.
 func (a *Accumulator) Stop() {
-	a.done <- true
+	a.cancel()
+	<-a.stopped
 }
 
 // The Ecobee API docs recommend polling no more frequently than 3 minutes.
@@ -247,7 +587,69 @@ var defaultPollInterval = time.Minute * 3
 
 // Opts for the Accumulator.
 type Opts struct {
+	// Account polled by the Accumulator, which need only be named if there are
+	// several. The name labels the metrics describing polls, and qualifies the
+	// thermostat identifiers served by Accounts.
+	Account string
+	// Labels added to every metric of every thermostat.
+	Labels map[string]string
+	// Selection of the thermostats to poll, and the data to fetch for each.
+	// Defaults to fetching everything for every registered thermostat.
+	Selection *Selection
+	// Sensors which are exported, and their locations. Defaults to all sensors,
+	// located by name.
+	Sensors *Sensors
+
 	PollInterval time.Duration
+	// StalePolls is the number of poll intervals without a successful poll after
+	// which the Accumulator is not ready. Defaults to 3.
+	StalePolls int
+
+	// Unit in which to export temperatures. Defaults to UnitFahrenheit.
+	Unit Unit
+	// ThermostatUnits overrides Unit for the thermostats with these identifiers.
+	ThermostatUnits map[string]Unit
+}
+
+func (o *Opts) unitFor(id string) Unit {
+	if o == nil {
+		return UnitFahrenheit
+	}
+	if u, ok := o.ThermostatUnits[id]; ok {
+		return u
+	}
+	if o.Unit == "" {
+		return UnitFahrenheit
+	}
+	return o.Unit
+}
+
+func (o *Opts) account() string {
+	if o == nil {
+		return ""
+	}
+	return o.Account
+}
+
+func (o *Opts) labels() prometheus.Labels {
+	if o == nil {
+		return nil
+	}
+	return o.Labels
+}
+
+func (o *Opts) selection() *Selection {
+	if o == nil {
+		return nil
+	}
+	return o.Selection
+}
+
+func (o *Opts) sensors() *Sensors {
+	if o == nil {
+		return nil
+	}
+	return o.Sensors
 }
 
 func (o *Opts) pollInterval() time.Duration {
@@ -257,31 +659,59 @@ func (o *Opts) pollInterval() time.Duration {
 	return o.PollInterval
 }
 
-// New Accumulator.
-func New(c *egobee.Client, o *Opts) *Accumulator {
-	done := make(chan bool)
+func (o *Opts) stalePolls() int {
+	if o == nil || o.StalePolls < 1 {
+		return defaultStalePolls
+	}
+	return o.StalePolls
+}
+
+// New Accumulator, which polls the Ecobee API until ctx is done or Stop is
+// called. Cancelling ctx also cancels any API request in progress.
+func New(ctx context.Context, c *ecobee.Client, o *Opts) *Accumulator {
+	ctx, cancel := context.WithCancel(ctx)
 	a := &Accumulator{
-		client:      c,
-		done:        done,
-		thermostats: make(map[string]*thermostatMetrics),
+		client:       c,
+		opts:         o,
+		reconfigured: make(chan struct{}, 1),
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
+		states:       make(map[string]*thermostatState),
+		selection:    o.selection(),
 	}
 
-	go func(a *Accumulator, done <-chan bool) {
-		ticker := time.NewTicker(o.pollInterval())
-		if err := a.poll(); err != nil {
-			log.Printf("error polling: %v", err)
-		}
-		for {
+	go func(ctx context.Context, a *Accumulator) {
+		defer close(a.stopped)
+		interval := o.pollInterval()
+		ticker := time.NewTicker(interval)
+		defer func() { ticker.Stop() }()
+		for poll := true; ; {
+			if poll {
+				if err := a.poll(ctx); err != nil && ctx.Err() == nil {
+					if account := o.account(); account != "" {
+						log.Printf("error polling account %q: %v", account, err)
+					} else {
+						log.Printf("error polling: %v", err)
+					}
+				}
+			}
 			select {
-			case <-done:
+			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if err := a.poll(); err != nil {
-					log.Printf("error polling: %v", err)
+				poll = true
+			case <-a.reconfigured:
+				// Thermostats selected anew are fetched at once, rather than at the
+				// next tick.
+				poll = a.applyOptions()
+				if next := a.options().pollInterval(); next != interval {
+					interval = next
+					ticker.Stop()
+					ticker = time.NewTicker(interval)
 				}
 			}
 		}
-	}(a, done)
+	}(ctx, a)
 
 	return a
 }
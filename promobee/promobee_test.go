@@ -1,22 +1,33 @@
 package promobee
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/cfunkhouser/promobee/ecobee"
 )
 
 func TestAccumulator_ServeThermostatList(t *testing.T) {
-	acc := &Accumulator{
+	acc := &Accumulator{}
+	acc.snapshot.Store(&snapshot{
 		thermostats: map[string]*thermostatMetrics{
 			"id1": &thermostatMetrics{},
 			"id2": &thermostatMetrics{},
 			"id3": &thermostatMetrics{},
 		},
-	}
+	})
 
 	req, err := http.NewRequest(http.MethodGet, "/thermostats", nil)
 	if err != nil {
@@ -41,32 +52,28 @@ func TestAccumulator_ServeThermostatList(t *testing.T) {
 	}
 }
 
-func TestAccumulator_metricsForThermostat_doesNotExist(t *testing.T) {
+func TestAccumulator_state_doesNotExist(t *testing.T) {
 	testAccumulator := &Accumulator{
-		thermostats: make(map[string]*thermostatMetrics),
+		states: make(map[string]*thermostatState),
 	}
-	got := testAccumulator.metricsForThermostatIdentifier(&egobee.Thermostat{Identifier: "foo"})
+	got := testAccumulator.state("foo")
 	if got == nil {
-		t.Errorf("Accumulator.metricsForThermostatIdentifier(...) returned nil; it should never do that.")
+		t.Errorf("Accumulator.state(...) returned nil; it should never do that.")
 	}
-	if inMap := testAccumulator.thermostats["foo"]; inMap != got {
-		t.Errorf("Accumulator.metricsForThermostatIdentifier(...) returned a pointer that doesn't exist in the map somehow")
+	if inMap := testAccumulator.states["foo"]; inMap != got {
+		t.Errorf("Accumulator.state(...) returned a pointer that doesn't exist in the map somehow")
 	}
 }
 
-func TestAccumulator_metricsForThermostat_doesExist(t *testing.T) {
-	tm := &thermostatMetrics{}
+func TestAccumulator_state_doesExist(t *testing.T) {
+	ts := newThermostatState(UnitFahrenheit)
 	testAccumulator := &Accumulator{
-		thermostats: map[string]*thermostatMetrics{
-			"foo": tm,
+		states: map[string]*thermostatState{
+			"foo": ts,
 		},
 	}
-	got := testAccumulator.metricsForThermostatIdentifier(&egobee.Thermostat{Identifier: "foo"})
-	if got == nil {
-		t.Errorf("Accumulator.metricsForThermostatIdentifier(...) returned nil; it should never do that.")
-	}
-	if got != tm {
-		t.Errorf("Accumulator.metricsForThermostatIdentifier(...) returned a new *thermostatMetrics despite one being in the map")
+	if got := testAccumulator.state("foo"); got != ts {
+		t.Errorf("Accumulator.state(...) returned a new *thermostatState despite one being in the map")
 	}
 }
 
@@ -85,3 +92,357 @@ func TestOptsPollInterval(t *testing.T) {
 		}
 	}
 }
+
+func gaugeValue(t *testing.T, g interface{ Write(*dto.Metric) error }) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		t.Fatalf("failed writing metric: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func seriesCount(t *testing.T, c prometheus.Collector) int {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func counterValue(t *testing.T, c interface{ Write(*dto.Metric) error }) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatalf("failed writing metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestAccumulator_poll_keepsPartialPages(t *testing.T) {
+	a, srv := testAccumulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/thermostatSummary":
+			fmt.Fprint(w, `{"revisionList": ["1:One:true:t:a:r:i", "2:Two:true:t:a:r:i"]}`)
+		case "/1/thermostat":
+			if strings.Contains(r.URL.Query().Get("json"), `"page":2`) {
+				http.Error(w, "oops", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, `{"page": {"page": 1, "totalPages": 2}, "thermostatList": [{"identifier": "1"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if err := a.poll(context.Background()); err == nil {
+		t.Errorf("poll(): want error from failed page, got nil")
+	}
+	if got := a.states["1"].revision.ThermostatRev; got != "t" {
+		t.Errorf("thermostat from fetched page: got revision %q, want %q", got, "t")
+	}
+	if got := a.states["2"].revision.ThermostatRev; got != "" {
+		t.Errorf("thermostat from failed page: got revision %q, want none so it is retried", got)
+	}
+	m := &dto.Metric{}
+	if err := pollPagesFetched.WithLabelValues("").Write(m); err != nil {
+		t.Fatalf("failed writing metric: %v", err)
+	}
+	if got := m.GetGauge().GetValue(); got != 1 {
+		t.Errorf("poll_pages_fetched: got %v, want 1", got)
+	}
+}
+
+func TestThermostatMetrics_exportAlerts(t *testing.T) {
+	m := newThermostatMetrics(UnitFahrenheit, nil, nil)
+	th := &ecobee.Thermostat{}
+	th.Alerts = []egobee.Alert{
+		{AcknowledgeRef: "ref1", AlertNumber: 611, AlertType: "alert", Severity: "high", Text: "Furnace fault"},
+		{AcknowledgeRef: "ref2", AlertNumber: 1000, AlertType: "alert", Severity: "low", Text: "Low temperature"},
+	}
+	m.exportAlerts(th)
+	if got := gaugeValue(t, m.alertActiveMetric.WithLabelValues("611", "alert", "high")); got != 1 {
+		t.Errorf("alert_active for 611: got %v, want 1", got)
+	}
+	if got := gaugeValue(t, m.alertInfoMetric.WithLabelValues("611", "ref1", "Furnace fault")); got != 1 {
+		t.Errorf("alert_info for 611: got %v, want 1", got)
+	}
+
+	// Alerts which are no longer reported must disappear.
+	th.Alerts = th.Alerts[1:]
+	m.exportAlerts(th)
+	if got := seriesCount(t, m.alertActiveMetric); got != 1 {
+		t.Errorf("alert_active: got %d series, want 1", got)
+	}
+}
+
+func TestAccumulator_poll_dropsVanishedSeries(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+
+	api.mu.Lock()
+	api.revision = "2"
+	api.sensor = "Den"
+	api.mu.Unlock()
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	a.ServeThermostat(rr, httptest.NewRequest(http.MethodGet, "/thermostat?id=123", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, `temperature_fahrenheit{location="Den"} 72.5`) {
+		t.Errorf("ServeThermostat: renamed sensor missing from:\n%v", body)
+	}
+	if strings.Contains(body, `location="Kitchen"`) {
+		t.Errorf("ServeThermostat: sensor which is no longer reported is still exported:\n%v", body)
+	}
+}
+
+// TestAccumulator_concurrentScrapes is most useful with -race.
+func TestAccumulator_concurrentScrapes(t *testing.T) {
+	api := &fakeAPI{revision: "0"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(a.Collector())
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				a.ServeThermostat(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/thermostat?id=123", nil))
+				a.ServeThermostatsList(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/thermostats", nil))
+				if _, err := registry.Gather(); err != nil {
+					t.Errorf("Gather(): unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 20; i++ {
+		api.mu.Lock()
+		api.revision = strconv.Itoa(i)
+		api.sensor = fmt.Sprintf("Sensor %d", i%3)
+		api.mu.Unlock()
+		if err := a.poll(context.Background()); err != nil {
+			t.Errorf("poll(): unexpected error: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestAccumulator_Stop_cancelsPoll(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		// Hang until the request is cancelled.
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	ts := egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{
+		AccessToken: "access",
+		ExpiresIn:   egobee.TokenDuration{Duration: time.Hour},
+	})
+	a := New(context.Background(), ecobee.New("app", ts, &egobee.Options{APIHost: srv.URL}), nil)
+	<-requested
+
+	stopped := make(chan struct{})
+	go func() {
+		a.Stop()
+		a.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop() did not return while a poll was in progress")
+	}
+}
+
+func TestAccumulator_Reconfigure(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	a.opts = &Opts{Account: "home"}
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	state := a.states["123"]
+
+	a.Reconfigure(&Opts{
+		Account: "office",
+		Unit:    UnitCelsius,
+		Labels:  map[string]string{"site": "home"},
+		Sensors: &Sensors{Locations: map[string]string{"Kitchen": "kitchen"}},
+	})
+	a.applyOptions()
+	if got := a.options().Account; got != "home" {
+		t.Errorf("Reconfigure(...): account changed to %q", got)
+	}
+	if a.states["123"] != state {
+		t.Errorf("Reconfigure(...): thermostat state was not kept")
+	}
+	rr := httptest.NewRecorder()
+	a.ServeThermostat(rr, httptest.NewRequest(http.MethodGet, "/thermostat?id=123", nil))
+	if want := `temperature_celsius{location="kitchen",site="home"} 22.5`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("ServeThermostat: %q missing from:\n%v", want, rr.Body.String())
+	}
+}
+
+func TestAccumulator_selection(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	a.opts = &Opts{Selection: &Selection{
+		Type:          egobee.SelectionTypeManagementSet,
+		ManagementSet: "/Toronto",
+		Includes:      []Include{IncludeSensors},
+	}}
+	a.selection = a.opts.Selection
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	if want := `"selectionType":"managementSet","selectionMatch":"/Toronto"`; len(api.summaries) != 1 || !strings.Contains(api.summaries[0], want) {
+		t.Errorf("poll(): got summary selections %v, want one containing %v", api.summaries, want)
+	}
+	if fetches := api.fetches(); len(fetches) != 1 || fetches[0].IncludeSettings || !fetches[0].IncludeSensors {
+		t.Errorf("poll(): got fetches %+v, want one including only sensors", fetches)
+	}
+	serve := func() string {
+		rr := httptest.NewRecorder()
+		a.ServeThermostat(rr, httptest.NewRequest(http.MethodGet, "/thermostat?id=123", nil))
+		return rr.Body.String()
+	}
+	if got := serve(); !strings.Contains(got, `temperature_fahrenheit{location="Kitchen"} 72.5`) || strings.Contains(got, "hvac{") {
+		t.Errorf("ServeThermostat: want only sensor metrics, got:\n%v", got)
+	}
+
+	// Including settings fetches every section again, although no revision has
+	// changed.
+	a.Reconfigure(&Opts{Selection: &Selection{Includes: []Include{IncludeSensors, IncludeSettings}}})
+	if refetch := a.applyOptions(); !refetch {
+		t.Errorf("applyOptions(): got refetch false, want true")
+	}
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	if fetches := api.fetches(); len(fetches) != 2 || !fetches[1].IncludeSettings {
+		t.Errorf("poll(): got fetches %+v, want a second including settings", fetches)
+	}
+	if got := serve(); !strings.Contains(got, `hvac{mode="cool"} 1`) {
+		t.Errorf("ServeThermostat: want hvac mode, got:\n%v", got)
+	}
+	if refetch := a.applyOptions(); refetch {
+		t.Errorf("applyOptions() with unchanged selection: got refetch true, want false")
+	}
+}
+
+// TestAccumulator_poll_replay polls payloads recorded from the API with
+// --record, which are kept in testdata/replay.
+func TestAccumulator_poll_replay(t *testing.T) {
+	for _, tt := range []struct {
+		fixtures string
+		opts     *Opts
+		want     []string
+		notWant  []string
+	}{
+		{
+			// A sensor which is offline reports an unknown temperature, but
+			// still reports occupancy.
+			fixtures: "offline-sensor",
+			want: []string{
+				`temperature_fahrenheit{location="Upstairs"} 74.1`,
+				`humidity{location="Upstairs"} 48`,
+				`occupancy{location="Guest Room"} 0`,
+				`hvac_in_operation{equipment="compCool1"} 1`,
+				`equipment_runtime_seconds_total{equipment="compCool1"} 720`,
+				`desired_temperature_fahrenheit{type="cool"} 74`,
+				`connected 1`,
+				`current_climate{climate_ref="home",name="Home"} 1`,
+				`climate_temperature_fahrenheit{climate_ref="sleep",name="Sleep",type="heat"} 64`,
+				// The Guest Room only takes part in the Sleep climate, and is
+				// not in use now.
+				`sensor_in_use{location="Guest Room"} 0`,
+				`sensor_in_climate{climate="Home",location="Guest Room"} 0`,
+				`sensor_in_climate{climate="Sleep",location="Guest Room"} 1`,
+				`participating_sensors 1`,
+				`participating_temperature_fahrenheit 74.1`,
+				`participating_temperature_setpoint_offset_fahrenheit{type="cool"} 0.1`,
+			},
+			notWant: []string{`temperature_fahrenheit{location="Guest Room"}`},
+		},
+		{
+			// Sensors identified by sensor_id are still located by sensor_info.
+			fixtures: "offline-sensor",
+			opts: &Opts{Sensors: &Sensors{
+				Identity:  SensorIdentityID,
+				Locations: map[string]string{"Guest Room": "guest"},
+			}},
+			want: []string{
+				`temperature_fahrenheit{sensor_id="ei:0"} 74.1`,
+				`occupancy{sensor_id="rs:100"} 0`,
+				`sensor_info{code="VDBZ",location="guest",sensor_id="rs:100",sensor_type="ecobee3_remote_sensor"} 1`,
+				`sensor_info{code="",location="Upstairs",sensor_id="ei:0",sensor_type="ecobee3"} 1`,
+				`sensor_in_climate{climate="Sleep",sensor_id="rs:100"} 1`,
+			},
+			notWant: []string{`temperature_fahrenheit{location=`},
+		},
+	} {
+		replayer, err := ecobee.NewReplayer(filepath.Join("testdata", "replay", tt.fixtures))
+		if err != nil {
+			t.Fatalf("NewReplayer(%q): unexpected error: %v", tt.fixtures, err)
+		}
+		a, srv := testAccumulator(replayer)
+		a.opts = tt.opts
+		if err := a.poll(context.Background()); err != nil {
+			t.Errorf("%v: poll(): unexpected error: %v", tt.fixtures, err)
+		}
+		srv.Close()
+		ids := a.current().ids()
+		if len(ids) != 1 {
+			t.Errorf("%v: got thermostats %v, want one", tt.fixtures, ids)
+			continue
+		}
+		rr := httptest.NewRecorder()
+		a.ServeThermostat(rr, httptest.NewRequest(http.MethodGet, "/thermostat?id="+ids[0], nil))
+		for _, want := range tt.want {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%v: %q missing from:\n%v", tt.fixtures, want, rr.Body.String())
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(rr.Body.String(), notWant) {
+				t.Errorf("%v: %q unexpected in:\n%v", tt.fixtures, notWant, rr.Body.String())
+			}
+		}
+		rr = httptest.NewRecorder()
+		a.ServeSchedule(rr, httptest.NewRequest(http.MethodGet, "/thermostat/"+ids[0]+"/schedule", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("%v: ServeSchedule: got status %v, want %v", tt.fixtures, rr.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package promobee
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// section of Thermostat data which is revisioned independently by the API.
+type section string
+
+// Sections, named for the revisions in the ThermostatSummary RevisionList.
+const (
+	sectionThermostat section = "thermostat"
+	sectionAlerts     section = "alerts"
+	sectionRuntime    section = "runtime"
+	sectionInterval   section = "interval"
+)
+
+var allSections = []section{sectionThermostat, sectionAlerts, sectionRuntime, sectionInterval}
+
+func (s section) revision(r *ecobee.Revision) string {
+	switch s {
+	case sectionThermostat:
+		return r.ThermostatRev
+	case sectionAlerts:
+		return r.AlertsRev
+	case sectionRuntime:
+		return r.RuntimeRev
+	case sectionInterval:
+		return r.IntervalRev
+	}
+	return ""
+}
+
+// includes of the section, which change with its revision.
+func (s section) includes() []Include {
+	switch s {
+	case sectionThermostat:
+		return []Include{IncludeSettings, IncludeEvents, IncludeProgram}
+	case sectionAlerts:
+		return []Include{IncludeAlerts}
+	case sectionRuntime:
+		return []Include{IncludeRuntime, IncludeSensors, IncludeWeather}
+	case sectionInterval:
+		return []Include{IncludeExtendedRuntime}
+	}
+	return nil
+}
+
+// include sets the Selection includes of the section which are fetched.
+// Sections none of whose includes are fetched include nothing.
+func (s section) include(sel *egobee.Selection, fetched map[Include]bool) {
+	for _, i := range s.includes() {
+		if fetched[i] {
+			i.set(sel)
+		}
+	}
+}
+
+// fetched reports whether any data is fetched for the section.
+func (s section) fetched(includes map[Include]bool) bool {
+	sel := &egobee.Selection{}
+	s.include(sel, includes)
+	return *sel != (egobee.Selection{})
+}
+
+// changedSections returns the sections whose revision differs between prev and
+// cur, in a stable order.
+func changedSections(prev, cur *ecobee.Revision) []section {
+	var changed []section
+	for _, s := range allSections {
+		if s.revision(prev) != s.revision(cur) {
+			changed = append(changed, s)
+		}
+	}
+	return changed
+}
+
+// selectionFor the thermostats identified in ids, including only the data for
+// sections which is fetched.
+func selectionFor(ids []string, sections []section, includes map[Include]bool) *egobee.Selection {
+	sel := &egobee.Selection{
+		SelectionType:  egobee.SelectionTypeThermostats,
+		SelectionMatch: strings.Join(ids, ","),
+	}
+	for _, s := range sections {
+		s.include(sel, includes)
+	}
+	return sel
+}
+
+// sectionsKey uniquely identifies a set of sections, so that thermostats which
+// need the same sections can share a request.
+func sectionsKey(sections []section) string {
+	names := make([]string, len(sections))
+	for i, s := range sections {
+		names[i] = string(s)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,159 @@
+package promobee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+func TestChangedSections(t *testing.T) {
+	prev := &ecobee.Revision{ThermostatRev: "t1", AlertsRev: "a1", RuntimeRev: "r1", IntervalRev: "i1"}
+	cur := &ecobee.Revision{ThermostatRev: "t1", AlertsRev: "a1", RuntimeRev: "r2", IntervalRev: "i2"}
+	got := changedSections(prev, cur)
+	if want := []section{sectionRuntime, sectionInterval}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("changedSections(...): got %v, want %v", got, want)
+	}
+	if got := changedSections(cur, cur); len(got) != 0 {
+		t.Errorf("changedSections(...) with equal revisions: got %v, want none", got)
+	}
+}
+
+func TestSelectionFor(t *testing.T) {
+	got := selectionFor([]string{"1", "2"}, []section{sectionRuntime, sectionInterval}, (*Selection)(nil).includes())
+	want := &egobee.Selection{
+		SelectionType:          egobee.SelectionTypeThermostats,
+		SelectionMatch:         "1,2",
+		IncludeRuntime:         true,
+		IncludeSensors:         true,
+		IncludeWeather:         true,
+		IncludeExtendedRuntime: true,
+	}
+	if *got != *want {
+		t.Errorf("selectionFor(...): got %+v, want %+v", got, want)
+	}
+
+	sel := &Selection{Includes: []Include{IncludeSensors, IncludeExtendedRuntime}}
+	got = selectionFor([]string{"1"}, []section{sectionThermostat, sectionRuntime, sectionInterval}, sel.includes())
+	want = &egobee.Selection{
+		SelectionType:          egobee.SelectionTypeThermostats,
+		SelectionMatch:         "1",
+		IncludeSensors:         true,
+		IncludeExtendedRuntime: true,
+	}
+	if *got != *want {
+		t.Errorf("selectionFor(...) with includes: got %+v, want %+v", got, want)
+	}
+}
+
+// fakeAPI serves a minimal ecobee API for testing the Accumulator.
+type fakeAPI struct {
+	mu         sync.Mutex
+	revision   string
+	useCelsius bool
+	// sensor name reported by the thermostat; "Kitchen" if empty.
+	sensor     string
+	selections []*egobee.Selection
+	// summaries are the selections of thermostat summary requests.
+	summaries []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/1/thermostatSummary":
+		f.summaries = append(f.summaries, r.URL.Query().Get("json"))
+		fmt.Fprintf(w, `{"revisionList": ["123:Home:true:%v:a:%v:i"], "statusList": ["123:fan"], "thermostatCount": 1}`, f.revision, f.revision)
+	case "/1/thermostat":
+		sel := &struct {
+			Selection *egobee.Selection `json:"selection"`
+		}{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("json")), sel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.selections = append(f.selections, sel.Selection)
+		sensor := f.sensor
+		if sensor == "" {
+			sensor = "Kitchen"
+		}
+		fmt.Fprintf(w, `{
+		  "page": {"page": 1, "totalPages": 1},
+		  "thermostatList": [{
+		    "identifier": "123",
+		    "settings": {"hvacMode": "cool", "useCelsius": %v},
+		    "remoteSensors": [{"name": %q, "capability": [{"type": "temperature", "value": "725"}]}]
+		  }]
+		}`, f.useCelsius, sensor)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeAPI) fetches() []*egobee.Selection {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.selections
+}
+
+// testAccumulator returns an Accumulator which is not polling, backed by a
+// fake API served by h. The returned server must be closed by the caller.
+func testAccumulator(h http.Handler) (*Accumulator, *httptest.Server) {
+	srv := httptest.NewServer(h)
+	ts := egobee.NewMemoryTokenStore(&egobee.TokenRefreshResponse{
+		AccessToken: "access",
+		ExpiresIn:   egobee.TokenDuration{Duration: time.Hour},
+	})
+	return &Accumulator{
+		client: ecobee.New("app", ts, &egobee.Options{APIHost: srv.URL}),
+		states: make(map[string]*thermostatState),
+	}, srv
+}
+
+func TestAccumulator_poll_revisionGated(t *testing.T) {
+	api := &fakeAPI{revision: "1"}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	if got := len(api.fetches()); got != 1 {
+		t.Fatalf("first poll: got %d thermostat fetches, want 1", got)
+	}
+	first := api.fetches()[0]
+	if first.SelectionType != egobee.SelectionTypeThermostats || first.SelectionMatch != "123" || !first.IncludeSettings || !first.IncludeSensors {
+		t.Errorf("first poll: unexpected selection %+v", first)
+	}
+
+	// Unchanged revisions must not cause a fetch.
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	if got := len(api.fetches()); got != 1 {
+		t.Errorf("unchanged poll: got %d thermostat fetches, want 1", got)
+	}
+
+	api.mu.Lock()
+	api.revision = "2"
+	api.mu.Unlock()
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	fetches := api.fetches()
+	if len(fetches) != 2 {
+		t.Fatalf("changed poll: got %d thermostat fetches, want 2", len(fetches))
+	}
+	if last := fetches[1]; !last.IncludeSettings || !last.IncludeRuntime || last.IncludeExtendedRuntime {
+		t.Errorf("changed poll: selection should only include changed sections, got %+v", last)
+	}
+}
@@ -0,0 +1,156 @@
+package promobee
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// fanModes are the states of the desired_fan_mode state set. Other modes
+// reported by the API are exported as well.
+var fanModes = []string{"auto", "on"}
+
+// runtimeMetrics are exported from the last known running state of a
+// thermostat, which includes the setpoints of its current program even when no
+// hold is running.
+type runtimeMetrics struct {
+	desiredTemperature      *prometheus.GaugeVec
+	desiredTemperatureRange *prometheus.GaugeVec
+	desiredFanMode          *prometheus.GaugeVec
+	// The following have no labels, and are vectors so that nothing is exported
+	// until they are set.
+	actualTemperature *prometheus.GaugeVec
+	actualHumidity    *prometheus.GaugeVec
+	desiredHumidity   *prometheus.GaugeVec
+	desiredDehumidity *prometheus.GaugeVec
+	connected         *prometheus.GaugeVec
+	connectTime       *prometheus.GaugeVec
+	disconnectTime    *prometheus.GaugeVec
+
+	unit Unit
+}
+
+// newRuntimeMetrics which export temperatures in unit.
+func newRuntimeMetrics(unit Unit) *runtimeMetrics {
+	return &runtimeMetrics{
+		desiredTemperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("desired_temperature_%v", unit),
+				Help: fmt.Sprintf("Setpoint in %v which an Ecobee thermostat is maintaining, whether from its program or a hold.", unit.title()),
+			},
+			[]string{"type"}),
+		desiredTemperatureRange: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("desired_temperature_range_%v", unit),
+				Help: fmt.Sprintf("Bounds in %v of the setpoints which may be chosen on an Ecobee thermostat.", unit.title()),
+			},
+			[]string{"type", "bound"}),
+		actualTemperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("actual_temperature_%v", unit),
+				Help: fmt.Sprintf("Temperature in %v used by an Ecobee thermostat, averaged over its participating sensors.", unit.title()),
+			},
+			nil),
+		actualHumidity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "actual_humidity",
+				Help: "Relative humidity as a percentage, as reported by an Ecobee thermostat.",
+			},
+			nil),
+		desiredHumidity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "desired_humidity",
+				Help: "Humidification setpoint as a percentage of an Ecobee thermostat.",
+			},
+			nil),
+		desiredDehumidity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "desired_dehumidity",
+				Help: "Dehumidification setpoint as a percentage of an Ecobee thermostat.",
+			},
+			nil),
+		desiredFanMode: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "desired_fan_mode",
+				Help: "Fan mode of an Ecobee thermostat, as a state set: the current mode is '1', and the others '0'.",
+			},
+			[]string{"mode"}),
+		connected: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connected",
+				Help: "Whether an Ecobee thermostat is connected to the ecobee servers.",
+			},
+			nil),
+		connectTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connect_timestamp_seconds",
+				Help: "Time at which an Ecobee thermostat last connected to the ecobee servers.",
+			},
+			nil),
+		disconnectTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "disconnect_timestamp_seconds",
+				Help: "Time at which an Ecobee thermostat last disconnected from the ecobee servers.",
+			},
+			nil),
+		unit: unit,
+	}
+}
+
+func (m *runtimeMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.desiredTemperature, m.desiredTemperatureRange, m.desiredFanMode, m.actualTemperature, m.actualHumidity,
+		m.desiredHumidity, m.desiredDehumidity, m.connected, m.connectTime, m.disconnectTime,
+	}
+}
+
+// temperature converts a temperature in tenths of a degree Fahrenheit.
+func (m *runtimeMetrics) temperature(v int) float64 {
+	return m.unit.fromFahrenheit(float64(v) / 10)
+}
+
+// export the metrics from r.
+func (m *runtimeMetrics) export(r *egobee.Runtime) {
+	m.desiredTemperature.WithLabelValues("heat").Set(m.temperature(r.DesiredHeat))
+	m.desiredTemperature.WithLabelValues("cool").Set(m.temperature(r.DesiredCool))
+	for typ, bounds := range map[string][]int{"heat": r.DesiredHeatRange, "cool": r.DesiredCoolRange} {
+		if len(bounds) != 2 {
+			continue
+		}
+		m.desiredTemperatureRange.WithLabelValues(typ, "min").Set(m.temperature(bounds[0]))
+		m.desiredTemperatureRange.WithLabelValues(typ, "max").Set(m.temperature(bounds[1]))
+	}
+	m.actualTemperature.WithLabelValues().Set(m.temperature(r.ActualTemperature))
+	m.actualHumidity.WithLabelValues().Set(float64(r.ActualHumidity))
+	m.desiredHumidity.WithLabelValues().Set(float64(r.DesiredHumidity))
+	m.desiredDehumidity.WithLabelValues().Set(float64(r.DesiredDehumidity))
+
+	for _, mode := range fanModes {
+		m.desiredFanMode.WithLabelValues(mode).Set(0)
+	}
+	if r.DesiredFanMode != "" {
+		m.desiredFanMode.WithLabelValues(r.DesiredFanMode).Set(1)
+	}
+
+	connected := 0.0
+	if r.Connected {
+		connected = 1
+	}
+	m.connected.WithLabelValues().Set(connected)
+	setTimestamp(m.connectTime, r.ConnectDateTime)
+	setTimestamp(m.disconnectTime, r.DisconnectDateTime)
+}
+
+// setTimestamp sets g to the time of ts, an API timestamp in UTC, unless it is
+// empty or invalid.
+func setTimestamp(g *prometheus.GaugeVec, ts string) {
+	t, err := time.Parse(ecobee.TimestampFormat, ts)
+	if err != nil {
+		return
+	}
+	g.WithLabelValues().Set(float64(t.Unix()))
+}
@@ -0,0 +1,62 @@
+package promobee
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestRuntimeMetrics_export(t *testing.T) {
+	m := newRuntimeMetrics(UnitCelsius)
+	if got := seriesCount(t, m.actualTemperature); got != 0 {
+		t.Errorf("actual_temperature_celsius: got %d series before export, want 0", got)
+	}
+	m.export(&egobee.Runtime{
+		Connected:          true,
+		ConnectDateTime:    "2020-07-16 21:45:00",
+		DisconnectDateTime: "",
+		ActualTemperature:  716,
+		ActualHumidity:     45,
+		DesiredHeat:        680,
+		DesiredCool:        770,
+		DesiredHumidity:    36,
+		DesiredDehumidity:  60,
+		DesiredFanMode:     "on",
+		DesiredHeatRange:   []int{450, 790},
+		DesiredCoolRange:   []int{650, 920},
+	})
+
+	if got := gaugeValue(t, m.desiredTemperature.WithLabelValues("heat")); got != 20 {
+		t.Errorf("desired heat: got %v, want 20", got)
+	}
+	if got := gaugeValue(t, m.desiredTemperature.WithLabelValues("cool")); got != 25 {
+		t.Errorf("desired cool: got %v, want 25", got)
+	}
+	if got := gaugeValue(t, m.desiredTemperatureRange.WithLabelValues("cool", "max")); got != 33.33 {
+		t.Errorf("desired cool range max: got %v, want 33.33", got)
+	}
+	if got := gaugeValue(t, m.actualTemperature.WithLabelValues()); got != 22 {
+		t.Errorf("actual temperature: got %v, want 22", got)
+	}
+	if got := gaugeValue(t, m.desiredDehumidity.WithLabelValues()); got != 60 {
+		t.Errorf("desired dehumidity: got %v, want 60", got)
+	}
+	if got := gaugeValue(t, m.desiredFanMode.WithLabelValues("on")); got != 1 {
+		t.Errorf("desired fan mode on: got %v, want 1", got)
+	}
+	if got := gaugeValue(t, m.desiredFanMode.WithLabelValues("auto")); got != 0 {
+		t.Errorf("desired fan mode auto: got %v, want 0", got)
+	}
+	if got := gaugeValue(t, m.connected.WithLabelValues()); got != 1 {
+		t.Errorf("connected: got %v, want 1", got)
+	}
+	want := time.Date(2020, 7, 16, 21, 45, 0, 0, time.UTC)
+	if got := gaugeValue(t, m.connectTime.WithLabelValues()); got != float64(want.Unix()) {
+		t.Errorf("connect timestamp: got %v, want %v", got, want.Unix())
+	}
+	// A thermostat which has never disconnected has no disconnect time.
+	if got := seriesCount(t, m.disconnectTime); got != 0 {
+		t.Errorf("disconnect_timestamp_seconds: got %d series, want 0", got)
+	}
+}
@@ -0,0 +1,147 @@
+package promobee
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// Include is a portion of thermostat data which may be fetched, named as the
+// Include fields of egobee.Selection, without the prefix.
+type Include string
+
+// Includes from which metrics are exported. The metrics of each are only
+// exported if it is fetched.
+const (
+	// IncludeSettings exports the HVAC mode, and resolves UnitAuto.
+	IncludeSettings Include = "settings"
+	// IncludeEvents exports hold temperatures, which also requires
+	// IncludeSettings.
+	IncludeEvents Include = "events"
+	// IncludeProgram exports the climates of the program, and serves its
+	// schedule.
+	IncludeProgram Include = "program"
+	// IncludeAlerts exports alerts.
+	IncludeAlerts Include = "alerts"
+	// IncludeRuntime exports the setpoints and indoor state which the thermostat
+	// is maintaining, and whether it is connected.
+	IncludeRuntime Include = "runtime"
+	// IncludeSensors exports the temperature, humidity and occupancy reported by
+	// each sensor.
+	IncludeSensors Include = "sensors"
+	// IncludeWeather exports the weather forecast.
+	IncludeWeather Include = "weather"
+	// IncludeExtendedRuntime exports the runtime of HVAC equipment.
+	IncludeExtendedRuntime Include = "extendedRuntime"
+)
+
+var allIncludes = []Include{IncludeSettings, IncludeEvents, IncludeProgram, IncludeAlerts, IncludeRuntime, IncludeSensors, IncludeWeather, IncludeExtendedRuntime}
+
+// ParseInclude from its name.
+func ParseInclude(s string) (Include, error) {
+	for _, i := range allIncludes {
+		if strings.EqualFold(s, string(i)) {
+			return i, nil
+		}
+	}
+	names := make([]string, len(allIncludes))
+	for i, include := range allIncludes {
+		names[i] = string(include)
+	}
+	return "", fmt.Errorf("invalid include %q; must be one of %v", s, strings.Join(names, ", "))
+}
+
+// set the field of sel which fetches the include.
+func (i Include) set(sel *egobee.Selection) {
+	switch i {
+	case IncludeSettings:
+		sel.IncludeSettings = true
+	case IncludeEvents:
+		sel.IncludeEvents = true
+	case IncludeProgram:
+		sel.IncludeProgram = true
+	case IncludeAlerts:
+		sel.IncludeAlerts = true
+	case IncludeRuntime:
+		sel.IncludeRuntime = true
+	case IncludeSensors:
+		sel.IncludeSensors = true
+	case IncludeWeather:
+		sel.IncludeWeather = true
+	case IncludeExtendedRuntime:
+		sel.IncludeExtendedRuntime = true
+	}
+}
+
+// Selection of the thermostats to poll, and the data to fetch for each.
+type Selection struct {
+	// Type is egobee.SelectionTypeRegistered, which is the default,
+	// egobee.SelectionTypeThermostats or egobee.SelectionTypeManagementSet.
+	Type egobee.SelectionType
+	// Thermostats identifies the thermostats polled with
+	// egobee.SelectionTypeThermostats.
+	Thermostats []string
+	// ManagementSet, such as "/Toronto", is polled with
+	// egobee.SelectionTypeManagementSet. Its thermostats may only be polled with
+	// an EMS API key.
+	ManagementSet string
+	// Includes which are fetched. Defaults to all.
+	Includes []Include
+}
+
+// Check returns an error if the thermostats selected are not identified as the
+// Type requires.
+func (s *Selection) Check() error {
+	switch s.Type {
+	case "", egobee.SelectionTypeRegistered:
+		if len(s.Thermostats) > 0 || s.ManagementSet != "" {
+			return fmt.Errorf("registered selection matches every thermostat; thermostats and management set must not be set")
+		}
+	case egobee.SelectionTypeThermostats:
+		if len(s.Thermostats) < 1 {
+			return fmt.Errorf("thermostats selection requires thermostat identifiers")
+		}
+		if s.ManagementSet != "" {
+			return fmt.Errorf("thermostats selection must not set a management set")
+		}
+	case egobee.SelectionTypeManagementSet:
+		if s.ManagementSet == "" {
+			return fmt.Errorf("managementSet selection requires a management set, such as \"/\"")
+		}
+		if len(s.Thermostats) > 0 {
+			return fmt.Errorf("managementSet selection must not set thermostats")
+		}
+	default:
+		return fmt.Errorf("invalid selection type %q; must be one of %v, %v or %v", s.Type,
+			egobee.SelectionTypeRegistered, egobee.SelectionTypeThermostats, egobee.SelectionTypeManagementSet)
+	}
+	return nil
+}
+
+// summary is the selection of the thermostat summary, which determines the
+// thermostats polled.
+func (s *Selection) summary() *egobee.Selection {
+	switch {
+	case s == nil:
+		return nil
+	case s.Type == egobee.SelectionTypeThermostats:
+		return &egobee.Selection{SelectionType: s.Type, SelectionMatch: strings.Join(s.Thermostats, ",")}
+	case s.Type == egobee.SelectionTypeManagementSet:
+		return &egobee.Selection{SelectionType: s.Type, SelectionMatch: s.ManagementSet}
+	}
+	return nil
+}
+
+// includes which are fetched.
+func (s *Selection) includes() map[Include]bool {
+	includes := allIncludes
+	if s != nil && len(s.Includes) > 0 {
+		includes = s.Includes
+	}
+	m := make(map[Include]bool, len(includes))
+	for _, i := range includes {
+		m[i] = true
+	}
+	return m
+}
@@ -0,0 +1,47 @@
+package promobee
+
+import (
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestParseInclude(t *testing.T) {
+	if got, err := ParseInclude("ExtendedRuntime"); err != nil || got != IncludeExtendedRuntime {
+		t.Errorf("ParseInclude(%q): got %v, %v, want %v", "ExtendedRuntime", got, err, IncludeExtendedRuntime)
+	}
+	if _, err := ParseInclude("device"); err == nil {
+		t.Errorf("ParseInclude(%q): want error, got nil", "device")
+	}
+}
+
+func TestSelection_Check(t *testing.T) {
+	for _, tt := range []struct {
+		sel     *Selection
+		wantErr bool
+	}{
+		{sel: &Selection{}},
+		{sel: &Selection{Type: egobee.SelectionTypeRegistered, Includes: []Include{IncludeSensors}}},
+		{sel: &Selection{Type: egobee.SelectionTypeThermostats, Thermostats: []string{"1", "2"}}},
+		{sel: &Selection{Type: egobee.SelectionTypeManagementSet, ManagementSet: "/"}},
+		{sel: &Selection{Thermostats: []string{"1"}}, wantErr: true},
+		{sel: &Selection{Type: egobee.SelectionTypeThermostats}, wantErr: true},
+		{sel: &Selection{Type: egobee.SelectionTypeManagementSet, Thermostats: []string{"1"}, ManagementSet: "/"}, wantErr: true},
+		{sel: &Selection{Type: egobee.SelectionTypeManagementSet}, wantErr: true},
+		{sel: &Selection{Type: "everything"}, wantErr: true},
+	} {
+		if err := tt.sel.Check(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Check(): got %v, want error %v", tt.sel, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSelection_summary(t *testing.T) {
+	if got := (*Selection)(nil).summary(); got != nil {
+		t.Errorf("summary() of nil Selection: got %+v, want nil", got)
+	}
+	got := (&Selection{Type: egobee.SelectionTypeThermostats, Thermostats: []string{"1", "2"}}).summary()
+	if want := (&egobee.Selection{SelectionType: egobee.SelectionTypeThermostats, SelectionMatch: "1,2"}); got == nil || *got != *want {
+		t.Errorf("summary(): got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package promobee
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// SensorIdentity is the label which identifies a sensor in the metrics it
+// reports.
+type SensorIdentity string
+
+// Sensor identities.
+const (
+	// SensorIdentityLocation labels sensors by location, which is their name
+	// unless mapped by Sensors.Locations. It is the default.
+	SensorIdentityLocation SensorIdentity = "location"
+	// SensorIdentityID labels sensors by sensor_id, which does not change when a
+	// sensor is renamed. sensor_info joins it to the location.
+	SensorIdentityID SensorIdentity = "sensor_id"
+)
+
+// ParseSensorIdentity from its name.
+func ParseSensorIdentity(s string) (SensorIdentity, error) {
+	switch i := SensorIdentity(strings.ToLower(s)); i {
+	case SensorIdentityLocation, SensorIdentityID:
+		return i, nil
+	}
+	return "", fmt.Errorf("invalid sensor identity %q; must be one of location or sensor_id", s)
+}
+
+// Sensors selects the sensors of each thermostat which are exported, and the
+// location label of their metrics.
+type Sensors struct {
+	// Include, if not empty, exports only sensors whose names match one of these.
+	Include []*regexp.Regexp
+	// Exclude sensors whose names match any of these, even if included.
+	Exclude []*regexp.Regexp
+	// Locations maps the names of sensors to their location label. Sensors which
+	// are not mapped are located by name.
+	Locations map[string]string
+	// Identity labels the metrics of each sensor. Defaults to
+	// SensorIdentityLocation.
+	Identity SensorIdentity
+	// Capabilities overrides the units and scaling of the sensor_capability
+	// metrics of types of capability, such as "airPressure".
+	Capabilities map[string]Capability
+}
+
+// capability describing how capabilities of type typ are exported.
+func (s *Sensors) capability(typ string) Capability {
+	if s != nil {
+		if c, ok := s.Capabilities[typ]; ok {
+			return c
+		}
+	}
+	return capabilities[typ]
+}
+
+// label which identifies each sensor in its metrics.
+func (s *Sensors) label() string {
+	if s == nil || s.Identity == "" {
+		return string(SensorIdentityLocation)
+	}
+	return string(s.Identity)
+}
+
+// identify sensor by the value of its label, and report whether it is exported
+// at all.
+func (s *Sensors) identify(sensor *egobee.RemoteSensor) (string, bool) {
+	location, ok := s.location(sensor.Name)
+	if !ok {
+		return "", false
+	}
+	if s.label() == string(SensorIdentityID) {
+		return sensor.ID, true
+	}
+	return location, true
+}
+
+// climateSensorID is the identifier of the sensor whose capability is
+// identified by id in the sensors of a climate, such as "rs:100" for
+// "rs:100:1".
+func climateSensorID(id string) string {
+	if i := strings.LastIndex(id, ":"); i > strings.Index(id, ":") {
+		return id[:i]
+	}
+	return id
+}
+
+// location of the sensor named name, and whether it is exported at all.
+func (s *Sensors) location(name string) (string, bool) {
+	if s == nil {
+		return name, true
+	}
+	if len(s.Include) > 0 && !matchAny(s.Include, name) {
+		return "", false
+	}
+	if matchAny(s.Exclude, name) {
+		return "", false
+	}
+	if location, ok := s.Locations[name]; ok {
+		return location, true
+	}
+	return name, true
+}
+
+func matchAny(rxs []*regexp.Regexp, s string) bool {
+	for _, rx := range rxs {
+		if rx.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package promobee
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestSensors_location(t *testing.T) {
+	s := &Sensors{
+		Include:   []*regexp.Regexp{regexp.MustCompile("^(?:.*Room|Garage)$")},
+		Exclude:   []*regexp.Regexp{regexp.MustCompile("^(?:Garage)$")},
+		Locations: map[string]string{"Living Room": "living_room"},
+	}
+	for _, tt := range []struct {
+		name, want string
+		wantOK     bool
+	}{
+		{name: "Living Room", want: "living_room", wantOK: true},
+		{name: "Dining Room", want: "Dining Room", wantOK: true},
+		{name: "Garage"},
+		{name: "Attic"},
+	} {
+		got, ok := s.location(tt.name)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("location(%q): got %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+	if got, ok := (*Sensors)(nil).location("Attic"); got != "Attic" || !ok {
+		t.Errorf("nil location(%q): got %q, %v, want it unchanged", "Attic", got, ok)
+	}
+}
+
+func TestSensors_identify(t *testing.T) {
+	sensor := &egobee.RemoteSensor{ID: "rs:100", Name: "Living Room"}
+	for _, tt := range []struct {
+		sensors   *Sensors
+		wantLabel string
+		want      string
+		wantOK    bool
+	}{
+		{sensors: nil, wantLabel: "location", want: "Living Room", wantOK: true},
+		{sensors: &Sensors{Locations: map[string]string{"Living Room": "living_room"}}, wantLabel: "location", want: "living_room", wantOK: true},
+		{sensors: &Sensors{Identity: SensorIdentityID, Locations: map[string]string{"Living Room": "living_room"}}, wantLabel: "sensor_id", want: "rs:100", wantOK: true},
+		{sensors: &Sensors{Identity: SensorIdentityID, Exclude: []*regexp.Regexp{regexp.MustCompile("^Living Room$")}}, wantLabel: "sensor_id"},
+	} {
+		if got := tt.sensors.label(); got != tt.wantLabel {
+			t.Errorf("%+v label(): got %q, want %q", tt.sensors, got, tt.wantLabel)
+		}
+		if got, ok := tt.sensors.identify(sensor); got != tt.want || ok != tt.wantOK {
+			t.Errorf("%+v identify(%+v): got %q, %v, want %q, %v", tt.sensors, sensor, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseSensorIdentity(t *testing.T) {
+	for _, s := range []string{"location", "sensor_id", "SENSOR_ID"} {
+		if _, err := ParseSensorIdentity(s); err != nil {
+			t.Errorf("ParseSensorIdentity(%q): unexpected error: %v", s, err)
+		}
+	}
+	if _, err := ParseSensorIdentity("name"); err == nil {
+		t.Errorf("ParseSensorIdentity(%q): want error, got nil", "name")
+	}
+}
+
+func TestClimateSensorID(t *testing.T) {
+	for id, want := range map[string]string{
+		"rs:100:1": "rs:100",
+		"ei:0:1":   "ei:0",
+		"rs:100":   "rs:100",
+		"":         "",
+	} {
+		if got := climateSensorID(id); got != want {
+			t.Errorf("climateSensorID(%q): got %q, want %q", id, got, want)
+		}
+	}
+}
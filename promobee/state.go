@@ -0,0 +1,200 @@
+package promobee
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// thermostatState is the data from which the metrics of a thermostat are built.
+// It is kept between polls, so that sections which have not changed need not be
+// fetched again. It is only used by the polling goroutine.
+type thermostatState struct {
+	// name of the thermostat, as reported in the thermostat summary.
+	name string
+	// equipment which was running as of the thermostat summary.
+	equipment []string
+
+	// configuredUnit may be UnitAuto, in which case unit is resolved from the
+	// settings in the thermostat section.
+	configuredUnit Unit
+	unit           Unit
+
+	// sections most recently fetched, each of which contains only the data for
+	// that section.
+	sections map[section]*ecobee.Thermostat
+
+	// runtime in seconds of each piece of equipment, accumulated from the
+	// ExtendedRuntime intervals up to and including lastRuntimeInterval.
+	runtime             map[string]float64
+	lastRuntimeInterval time.Time
+
+	// revision of each section which has most recently been fetched, and the time
+	// at which each changed.
+	revision        ecobee.Revision
+	revisionChanged map[section]time.Time
+	// refetch every section on the next poll, regardless of revision, because
+	// the selection changed.
+	refetch bool
+}
+
+func newThermostatState(unit Unit) *thermostatState {
+	return &thermostatState{
+		configuredUnit:  unit,
+		unit:            unit.initial(),
+		sections:        make(map[section]*ecobee.Thermostat),
+		runtime:         make(map[string]float64),
+		revisionChanged: make(map[section]time.Time),
+	}
+}
+
+// accumulateRuntime adds each interval newer than the last one seen to the
+// equipment runtime. The API reports the same 15 minutes of intervals until the
+// thermostat next uploads, so intervals are only counted once.
+func (s *thermostatState) accumulateRuntime(r *ecobee.ExtendedRuntime) error {
+	intervals, err := r.Intervals()
+	if err != nil {
+		return err
+	}
+	for _, interval := range intervals {
+		if !interval.Time.After(s.lastRuntimeInterval) {
+			continue
+		}
+		for equipment, seconds := range interval.Runtime {
+			s.runtime[equipment] += float64(seconds)
+		}
+		s.lastRuntimeInterval = interval.Time
+	}
+	return nil
+}
+
+// setUnit configures the unit of the thermostat, resolving it from the settings
+// already fetched if necessary.
+func (s *thermostatState) setUnit(u Unit) {
+	s.configuredUnit = u
+	if t, ok := s.sections[sectionThermostat]; ok {
+		s.unit = u.resolve(t)
+		return
+	}
+	s.unit = u.initial()
+}
+
+// update the state with the fetched sections of thermostat, which include
+// includes.
+func (s *thermostatState) update(thermostat *ecobee.Thermostat, fetched []section, includes map[Include]bool) {
+	for _, sec := range fetched {
+		switch sec {
+		case sectionThermostat:
+			s.unit = s.configuredUnit.resolve(thermostat)
+		case sectionRuntime:
+			if includes[IncludeSensors] && len(thermostat.RemoteSensors) < 1 {
+				log.Printf("Thermostat %q has no sensors.", thermostat.Identifier)
+			}
+		case sectionInterval:
+			if err := s.accumulateRuntime(&thermostat.ExtendedRuntime); err != nil {
+				log.Printf("Error accumulating runtime for %q: %v", thermostat.Identifier, err)
+			}
+			continue
+		}
+		s.sections[sec] = thermostat
+	}
+}
+
+// updateRevision records the revision of each of sections, which have been
+// successfully fetched, and when it changed.
+func (s *thermostatState) updateRevision(rev *ecobee.Revision, sections []section, now time.Time) {
+	prev := s.revision
+	s.refetch = false
+	for _, sec := range sections {
+		switch sec {
+		case sectionThermostat:
+			s.revision.ThermostatRev = rev.ThermostatRev
+		case sectionAlerts:
+			s.revision.AlertsRev = rev.AlertsRev
+		case sectionRuntime:
+			s.revision.RuntimeRev = rev.RuntimeRev
+		case sectionInterval:
+			s.revision.IntervalRev = rev.IntervalRev
+		}
+		if sec.revision(&prev) != sec.revision(rev) {
+			s.revisionChanged[sec] = now
+		}
+	}
+}
+
+// metrics built from the current state, as configured by o.
+func (s *thermostatState) metrics(o *Opts) *thermostatMetrics {
+	m := newThermostatMetrics(s.unit, o.labels(), o.sensors())
+	m.name = s.name
+	for _, equipment := range s.equipment {
+		m.hvacInOperation.WithLabelValues(equipment).Set(1)
+	}
+	// Sections fetched before the selection changed may hold data which is no
+	// longer included, and is not exported.
+	includes := o.selection().includes()
+	if t, ok := s.sections[sectionThermostat]; ok && includes[IncludeSettings] {
+		m.exportThermostat(t, includes[IncludeEvents])
+		m.setpoints = newSetpointRange(&t.Settings)
+	}
+	if t, ok := s.sections[sectionThermostat]; ok && includes[IncludeProgram] {
+		m.program.export(&t.Program)
+		if len(t.Program.Schedule) > 0 {
+			m.schedule = newSchedule(&t.Program, s.unit)
+		}
+		var remote []egobee.RemoteSensor
+		if r, ok := s.sections[sectionRuntime]; ok && includes[IncludeSensors] {
+			remote = r.RemoteSensors
+		}
+		m.participation.exportClimates(&t.Program, remote, o.sensors())
+	}
+	if t, ok := s.sections[sectionAlerts]; ok && includes[IncludeAlerts] {
+		m.exportAlerts(t)
+	}
+	if t, ok := s.sections[sectionRuntime]; ok {
+		if includes[IncludeRuntime] {
+			m.runtime.export(&t.Runtime)
+		}
+		if includes[IncludeSensors] {
+			m.exportSensors(t, o.sensors())
+			// The setpoints are part of the runtime.
+			var r *egobee.Runtime
+			if includes[IncludeRuntime] {
+				r = &t.Runtime
+			}
+			m.participation.exportTemperature(t.RemoteSensors, r)
+		}
+		if includes[IncludeWeather] {
+			m.weather.export(&t.Weather)
+		}
+	}
+	if includes[IncludeExtendedRuntime] {
+		for equipment, seconds := range s.runtime {
+			m.runtimeMetric.WithLabelValues(equipment).Add(seconds)
+		}
+	}
+	for sec, t := range s.revisionChanged {
+		m.revisionChangeMetric.WithLabelValues(string(sec)).Set(float64(t.Unix()))
+	}
+	return m
+}
+
+// snapshot of the metrics of every thermostat, as of a single poll. Neither it
+// nor its thermostatMetrics are modified once published, so it may be read
+// without locking.
+type snapshot struct {
+	thermostats map[string]*thermostatMetrics
+}
+
+// ids of the thermostats in the snapshot, sorted for consistency.
+func (s *snapshot) ids() []string {
+	ids := make([]string, 0, len(s.thermostats))
+	for id := range s.thermostats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
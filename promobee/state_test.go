@@ -0,0 +1,77 @@
+package promobee
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+func TestThermostatState_accumulateRuntime(t *testing.T) {
+	s := newThermostatState(UnitFahrenheit)
+	first := &ecobee.ExtendedRuntime{
+		LastReadingTimestamp: "2020-07-01 12:10:00",
+		Fan:                  []int{300, 300, 120},
+		Cool1:                []int{0, 300, 0},
+	}
+	for i := 0; i < 2; i++ {
+		// Polling the same window twice must not double count.
+		if err := s.accumulateRuntime(first); err != nil {
+			t.Fatalf("accumulateRuntime(...): unexpected error: %v", err)
+		}
+	}
+	if got := s.runtime["fan"]; got != 720 {
+		t.Errorf("fan runtime: got %v, want 720", got)
+	}
+
+	// The next window overlaps the previous one by two intervals.
+	next := &ecobee.ExtendedRuntime{
+		LastReadingTimestamp: "2020-07-01 12:15:00",
+		Fan:                  []int{300, 120, 60},
+		Cool1:                []int{300, 0, 30},
+	}
+	if err := s.accumulateRuntime(next); err != nil {
+		t.Fatalf("accumulateRuntime(...): unexpected error: %v", err)
+	}
+	if got := s.runtime["fan"]; got != 780 {
+		t.Errorf("fan runtime: got %v, want 780", got)
+	}
+	if got := s.runtime["compCool1"]; got != 330 {
+		t.Errorf("compCool1 runtime: got %v, want 330", got)
+	}
+
+	if err := s.accumulateRuntime(&ecobee.ExtendedRuntime{}); err == nil {
+		t.Errorf("accumulateRuntime(...) with no timestamp: want error, got nil")
+	}
+}
+func TestThermostatState_metrics(t *testing.T) {
+	s := newThermostatState(UnitFahrenheit)
+	s.equipment = []string{"fan"}
+	s.runtime["fan"] = 600
+	now := time.Unix(1593600000, 0)
+	s.updateRevision(&ecobee.Revision{AlertsRev: "a"}, []section{sectionAlerts}, now)
+
+	m := s.metrics(nil)
+	if got := gaugeValue(t, m.hvacInOperation.WithLabelValues("fan")); got != 1 {
+		t.Errorf("hvac_in_operation: got %v, want 1", got)
+	}
+	if got := counterValue(t, m.runtimeMetric.WithLabelValues("fan")); got != 600 {
+		t.Errorf("equipment_runtime_seconds_total: got %v, want 600", got)
+	}
+	if got := gaugeValue(t, m.revisionChangeMetric.WithLabelValues("alerts")); got != float64(now.Unix()) {
+		t.Errorf("last_revision_change_timestamp_seconds: got %v, want %v", got, now.Unix())
+	}
+	// Sections which have never been fetched export nothing.
+	if got := seriesCount(t, m.tempMetric); got != 0 {
+		t.Errorf("temperature: got %d series, want 0", got)
+	}
+
+	// Metrics from an earlier snapshot are unaffected by later changes.
+	s.equipment = nil
+	if got := seriesCount(t, s.metrics(nil).hvacInOperation); got != 0 {
+		t.Errorf("hvac_in_operation after equipment stopped: got %d series, want 0", got)
+	}
+	if got := seriesCount(t, m.hvacInOperation); got != 1 {
+		t.Errorf("hvac_in_operation in earlier snapshot: got %d series, want 1", got)
+	}
+}
@@ -0,0 +1,91 @@
+package promobee
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// Unit of exported temperatures. The Ecobee API always reports Fahrenheit.
+type Unit string
+
+// Possible Units.
+const (
+	UnitFahrenheit Unit = "fahrenheit"
+	UnitCelsius    Unit = "celsius"
+	// UnitAuto follows the UseCelsius setting of each thermostat.
+	UnitAuto Unit = "auto"
+)
+
+// ParseUnit from its name.
+func ParseUnit(s string) (Unit, error) {
+	switch u := Unit(strings.ToLower(s)); u {
+	case UnitFahrenheit, UnitCelsius, UnitAuto:
+		return u, nil
+	}
+	return "", fmt.Errorf("invalid unit %q; must be one of fahrenheit, celsius or auto", s)
+}
+
+// title of the unit, for use in metric help.
+func (u Unit) title() string {
+	if u == UnitCelsius {
+		return "Celsius"
+	}
+	return "Fahrenheit"
+}
+
+// fromFahrenheit converts a temperature in Fahrenheit to the unit.
+func (u Unit) fromFahrenheit(f float64) float64 {
+	if u != UnitCelsius {
+		return f
+	}
+	// Round to hundredths, to avoid exporting conversion noise.
+	return math.Round((f-32)*5/9*100) / 100
+}
+
+// toFahrenheit converts a temperature in the unit to Fahrenheit.
+func (u Unit) toFahrenheit(t float64) float64 {
+	if u != UnitCelsius {
+		return t
+	}
+	return t*9/5 + 32
+}
+
+// resolve the unit for thermostat. Only UnitAuto depends on the thermostat, and
+// it requires the thermostat settings.
+func (u Unit) resolve(thermostat *ecobee.Thermostat) Unit {
+	if u != UnitAuto {
+		return u
+	}
+	if thermostat.Settings.UseCelsius {
+		return UnitCelsius
+	}
+	return UnitFahrenheit
+}
+
+// initial unit for a thermostat, before its settings are known.
+func (u Unit) initial() Unit {
+	if u == UnitCelsius {
+		return UnitCelsius
+	}
+	return UnitFahrenheit
+}
+
+// ParseThermostatUnits parses a list of thermostat=unit pairs.
+func ParseThermostatUnits(pairs []string) (map[string]Unit, error) {
+	units := make(map[string]Unit)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid thermostat unit %q; must be of the form thermostat=unit", pair)
+		}
+		u, err := ParseUnit(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		units[kv[0]] = u
+	}
+	return units, nil
+}
@@ -0,0 +1,107 @@
+package promobee
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseUnit(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Unit
+		wantErr bool
+	}{
+		{in: "fahrenheit", want: UnitFahrenheit},
+		{in: "Celsius", want: UnitCelsius},
+		{in: "auto", want: UnitAuto},
+		{in: "kelvin", wantErr: true},
+	} {
+		got, err := ParseUnit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUnit(%q): got error %v, want error: %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseUnit(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnitFromFahrenheit(t *testing.T) {
+	for _, tt := range []struct {
+		unit Unit
+		in   float64
+		want float64
+	}{
+		{UnitFahrenheit, 72.5, 72.5},
+		{UnitCelsius, 212, 100},
+		{UnitCelsius, 72.5, 22.5},
+		{UnitCelsius, 71, 21.67},
+	} {
+		if got := tt.unit.fromFahrenheit(tt.in); got != tt.want {
+			t.Errorf("%v.fromFahrenheit(%v): got %v, want %v", tt.unit, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseThermostatUnits(t *testing.T) {
+	got, err := ParseThermostatUnits([]string{"123=celsius", "456=auto"})
+	if err != nil {
+		t.Fatalf("ParseThermostatUnits(...): unexpected error: %v", err)
+	}
+	if got["123"] != UnitCelsius || got["456"] != UnitAuto || len(got) != 2 {
+		t.Errorf("ParseThermostatUnits(...): got %v", got)
+	}
+	for _, bad := range []string{"123", "=celsius", "123=kelvin"} {
+		if _, err := ParseThermostatUnits([]string{bad}); err == nil {
+			t.Errorf("ParseThermostatUnits(%q): expected error", bad)
+		}
+	}
+}
+
+func TestOptsUnitFor(t *testing.T) {
+	o := &Opts{Unit: UnitCelsius, ThermostatUnits: map[string]Unit{"123": UnitAuto}}
+	if got := o.unitFor("123"); got != UnitAuto {
+		t.Errorf("unitFor overridden thermostat: got %v, want %v", got, UnitAuto)
+	}
+	if got := o.unitFor("456"); got != UnitCelsius {
+		t.Errorf("unitFor other thermostat: got %v, want %v", got, UnitCelsius)
+	}
+	var nilOpts *Opts
+	if got := nilOpts.unitFor("456"); got != UnitFahrenheit {
+		t.Errorf("unitFor with nil Opts: got %v, want %v", got, UnitFahrenheit)
+	}
+}
+
+func TestAccumulator_poll_autoUnit(t *testing.T) {
+	api := &fakeAPI{revision: "1", useCelsius: true}
+	a, srv := testAccumulator(api)
+	defer srv.Close()
+	a.opts = &Opts{Unit: UnitAuto}
+
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	m := a.current().thermostats["123"]
+	if m.unit != UnitCelsius {
+		t.Fatalf("thermostat using Celsius: got unit %v, want %v", m.unit, UnitCelsius)
+	}
+	if got := gaugeValue(t, m.tempMetric.WithLabelValues("Kitchen")); got != 22.5 {
+		t.Errorf("temperature_celsius: got %v, want 22.5", got)
+	}
+
+	// Switching the thermostat back to Fahrenheit replaces the metrics.
+	api.mu.Lock()
+	api.useCelsius = false
+	api.revision = "2"
+	api.mu.Unlock()
+	if err := a.poll(context.Background()); err != nil {
+		t.Fatalf("poll(): unexpected error: %v", err)
+	}
+	m = a.current().thermostats["123"]
+	if m.unit != UnitFahrenheit {
+		t.Fatalf("thermostat using Fahrenheit: got unit %v, want %v", m.unit, UnitFahrenheit)
+	}
+	if got := gaugeValue(t, m.tempMetric.WithLabelValues("Kitchen")); got != 72.5 {
+		t.Errorf("temperature_fahrenheit: got %v, want 72.5", got)
+	}
+}
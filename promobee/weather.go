@@ -0,0 +1,124 @@
+package promobee
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+// weatherGauge exports a single field of each WeatherForecast.
+type weatherGauge struct {
+	vec   *prometheus.GaugeVec
+	value func(*egobee.WeatherForecast) int
+	// scale converts the API value to the exported unit.
+	scale float64
+	// unit converts temperatures, if set.
+	unit Unit
+}
+
+// weatherMetrics are labeled by the weather station reporting them, and the
+// index of the forecast: 0 is current conditions, and 1..n are future periods.
+type weatherMetrics struct {
+	gauges          []*weatherGauge
+	conditionMetric *prometheus.GaugeVec
+}
+
+var weatherLabels = []string{"station", "forecast"}
+
+func newWeatherGauge(name, help string, scale float64, value func(*egobee.WeatherForecast) int) *weatherGauge {
+	return &weatherGauge{
+		vec: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: name, Help: help},
+			weatherLabels),
+		value: value,
+		scale: scale,
+	}
+}
+
+func newWeatherTemperatureGauge(name, help string, unit Unit, value func(*egobee.WeatherForecast) int) *weatherGauge {
+	g := newWeatherGauge(fmt.Sprintf("%v_%v", name, unit), fmt.Sprintf(help, unit.title()), 10, value)
+	g.unit = unit
+	return g
+}
+
+func (g *weatherGauge) convert(v int) float64 {
+	f := float64(v) / g.scale
+	if g.unit != "" {
+		return g.unit.fromFahrenheit(f)
+	}
+	return f
+}
+
+// newWeatherMetrics which export temperatures in unit.
+func newWeatherMetrics(unit Unit) *weatherMetrics {
+	return &weatherMetrics{
+		gauges: []*weatherGauge{
+			newWeatherTemperatureGauge("weather_temperature",
+				"Outdoor temperature in %v as forecast for an Ecobee thermostat.", unit,
+				func(f *egobee.WeatherForecast) int { return f.Temperature }),
+			newWeatherGauge("weather_pressure_millibars",
+				"Barometric pressure in millibars as forecast for an Ecobee thermostat.", 1,
+				func(f *egobee.WeatherForecast) int { return f.Pressure }),
+			newWeatherGauge("weather_relative_humidity",
+				"Outdoor relative humidity as forecast for an Ecobee thermostat.", 1,
+				func(f *egobee.WeatherForecast) int { return f.RelativeHumidity }),
+			newWeatherTemperatureGauge("weather_dewpoint",
+				"Dewpoint in %v as forecast for an Ecobee thermostat.", unit,
+				func(f *egobee.WeatherForecast) int { return f.Dewpoint }),
+			newWeatherGauge("weather_wind_speed_mph",
+				"Wind speed in miles per hour as forecast for an Ecobee thermostat.", 1000,
+				func(f *egobee.WeatherForecast) int { return f.WindSpeed }),
+			newWeatherGauge("weather_wind_gust_mph",
+				"Wind gust speed in miles per hour as forecast for an Ecobee thermostat.", 1000,
+				func(f *egobee.WeatherForecast) int { return f.WindGust }),
+			newWeatherGauge("weather_wind_bearing_degrees",
+				"Wind bearing in degrees as forecast for an Ecobee thermostat.", 1,
+				func(f *egobee.WeatherForecast) int { return f.WindBearing }),
+			newWeatherGauge("weather_precipitation_probability",
+				"Probability of precipitation as a percentage, as forecast for an Ecobee thermostat.", 1,
+				func(f *egobee.WeatherForecast) int { return f.Pop }),
+			newWeatherGauge("weather_sky_cover",
+				"Sky cover as forecast for an Ecobee thermostat.", 1,
+				func(f *egobee.WeatherForecast) int { return f.Sky }),
+		},
+		conditionMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "weather_condition_info",
+				Help: "Weather condition as forecast for an Ecobee thermostat. Always '1'.",
+			},
+			[]string{"station", "forecast", "symbol", "condition"}),
+	}
+}
+
+func (m *weatherMetrics) collectors() []prometheus.Collector {
+	c := make([]prometheus.Collector, 0, len(m.gauges)+1)
+	for _, g := range m.gauges {
+		c = append(c, g.vec)
+	}
+	return append(c, m.conditionMetric)
+}
+
+// export replaces all weather metrics with those from w.
+func (m *weatherMetrics) export(w *egobee.Weather) {
+	for _, g := range m.gauges {
+		g.vec.Reset()
+	}
+	m.conditionMetric.Reset()
+
+	for i := range w.Forecasts {
+		f := &w.Forecasts[i]
+		forecast := strconv.Itoa(i)
+		for _, g := range m.gauges {
+			v := g.value(f)
+			if v == ecobee.WeatherUnknown {
+				continue
+			}
+			g.vec.WithLabelValues(w.WeatherStation, forecast).Set(g.convert(v))
+		}
+		m.conditionMetric.WithLabelValues(w.WeatherStation, forecast, ecobee.WeatherSymbolName(f.WeatherSymbol), f.Condition).Set(1)
+	}
+}
@@ -0,0 +1,43 @@
+package promobee
+
+import (
+	"testing"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+func TestWeatherMetrics_export(t *testing.T) {
+	m := newWeatherMetrics(UnitFahrenheit)
+	m.export(&egobee.Weather{
+		WeatherStation: "KBOS",
+		Forecasts: []egobee.WeatherForecast{
+			{WeatherSymbol: 2, Condition: "Partly Cloudy", Temperature: 715, Pressure: 1013, WindSpeed: 5500, Dewpoint: -5002},
+			{WeatherSymbol: 6, Condition: "Rain", Temperature: 650, Pop: 80},
+		},
+	})
+
+	temp := m.gauges[0]
+	if got := gaugeValue(t, temp.vec.WithLabelValues("KBOS", "0")); got != 71.5 {
+		t.Errorf("current temperature: got %v, want 71.5", got)
+	}
+	if got := gaugeValue(t, temp.vec.WithLabelValues("KBOS", "1")); got != 65 {
+		t.Errorf("forecast temperature: got %v, want 65", got)
+	}
+	wind := m.gauges[4]
+	if got := gaugeValue(t, wind.vec.WithLabelValues("KBOS", "0")); got != 5.5 {
+		t.Errorf("wind speed: got %v, want 5.5", got)
+	}
+	// Unknown values are not exported.
+	if got := seriesCount(t, m.gauges[3].vec); got != 1 {
+		t.Errorf("dewpoint: got %d series, want 1 since the current dewpoint is unknown", got)
+	}
+	if got := gaugeValue(t, m.conditionMetric.WithLabelValues("KBOS", "1", "rain", "Rain")); got != 1 {
+		t.Errorf("weather_condition_info: got %v, want 1", got)
+	}
+
+	// A new export replaces all previous series.
+	m.export(&egobee.Weather{WeatherStation: "KBED", Forecasts: []egobee.WeatherForecast{{Temperature: 700}}})
+	if got := seriesCount(t, temp.vec); got != 1 {
+		t.Errorf("temperature: got %d series after re-export, want 1", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/promobee/config"
+	"github.com/cfunkhouser/promobee/ecobee"
+	"github.com/cfunkhouser/promobee/promobee"
+)
+
+// configWatchInterval is how often the configuration file is checked for
+// changes.
+const configWatchInterval = 5 * time.Second
+
+// polledAccount is an account being polled.
+type polledAccount struct {
+	account     *config.Account
+	client      *ecobee.Client
+	accumulator *promobee.Accumulator
+}
+
+// configured polls the accounts of a configuration file, and applies changes to
+// the file without restarting.
+type configured struct {
+	c          *cli.Context
+	path       string
+	defaults   promobee.Opts
+	clientOpts *egobee.Options
+
+	cfg      *config.Config
+	accounts *promobee.Accounts
+	polled   map[string]*polledAccount
+}
+
+// startConfigured polls each account in the configuration file at path. Settings
+// absent from the file are taken from defaults.
+func startConfigured(c *cli.Context, path string, defaults promobee.Opts) (*configured, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	clientOpts, err := clientOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := promobee.NewAccounts()
+	if err != nil {
+		return nil, err
+	}
+	r := &configured{
+		c:          c,
+		path:       path,
+		defaults:   defaults,
+		clientOpts: clientOpts,
+		accounts:   accounts,
+		polled:     make(map[string]*polledAccount),
+	}
+	if err := r.apply(cfg); err != nil {
+		r.Stop()
+		return nil, err
+	}
+	return r, nil
+}
+
+// accountsOf cfg, which is the account given by --api_key and --store if it
+// lists none.
+func (r *configured) accountsOf(cfg *config.Config) ([]*config.Account, error) {
+	if len(cfg.Accounts) > 0 {
+		return cfg.Accounts, nil
+	}
+	account := &config.Account{APIKey: r.c.String("api_key"), Store: r.c.String("store")}
+	if r.c.String("replay") == "" && (account.APIKey == "" || account.Store == "") {
+		return nil, fmt.Errorf("config %q lists no accounts, and --api_key or --store is not set", r.path)
+	}
+	return []*config.Account{account}, nil
+}
+
+// apply cfg to the accounts being polled. Accounts whose API key and token store
+// are unchanged keep polling with their new settings; the others are restarted.
+func (r *configured) apply(cfg *config.Config) error {
+	accounts, err := r.accountsOf(cfg)
+	if err != nil {
+		return err
+	}
+	next := make(map[string]*config.Account)
+	for _, account := range accounts {
+		next[account.Name] = account
+	}
+	for name, p := range r.polled {
+		if account, ok := next[name]; !ok || account.APIKey != p.account.APIKey || account.Store != p.account.Store {
+			r.stop(name)
+		}
+	}
+	for _, account := range accounts {
+		opts := cfg.Opts(account, r.defaults)
+		if p, ok := r.polled[account.Name]; ok {
+			p.account = account
+			p.accumulator.Reconfigure(opts)
+			continue
+		}
+		if err := r.start(account, opts); err != nil {
+			return err
+		}
+	}
+	r.cfg = cfg
+	return nil
+}
+
+// start polling account.
+func (r *configured) start(account *config.Account, opts *promobee.Opts) error {
+	client, err := openClient(r.c, account.Name, account.APIKey, account.Store, r.clientOpts)
+	if err != nil {
+		return err
+	}
+	client.WrapTransport(promobee.InstrumentTransport)
+	a := promobee.New(r.c.Context, client, opts)
+	if err := r.accounts.Add(a); err != nil {
+		a.Stop()
+		return err
+	}
+	r.polled[account.Name] = &polledAccount{account: account, client: client, accumulator: a}
+	return nil
+}
+
+// stop polling the account named name, and flush its token store.
+func (r *configured) stop(name string) {
+	p := r.polled[name]
+	delete(r.polled, name)
+	r.accounts.Remove(name)
+	p.accumulator.Stop()
+	if err := p.client.FlushTokenStore(); err != nil {
+		log.Printf("Error flushing token store of account %q: %v", name, err)
+	}
+}
+
+// Reload the configuration file, logging what changed. An invalid file is
+// logged and otherwise ignored.
+func (r *configured) Reload() {
+	cfg, changes, err := config.Reload(r.path, r.cfg)
+	if err != nil {
+		log.Printf("Not reloading: %v", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	log.Printf("Reloading config %q:", r.path)
+	for _, change := range changes {
+		log.Printf("  %v", change)
+	}
+	if err := r.apply(cfg); err != nil {
+		log.Printf("Error applying config %q: %v", r.path, err)
+	}
+}
+
+// ListenAddress from the configuration file, or fallback if it sets none.
+func (r *configured) ListenAddress(fallback string) string {
+	if r.cfg.ListenAddress == "" {
+		return fallback
+	}
+	return r.cfg.ListenAddress
+}
+
+// Stop polling every account, and flush their token stores.
+func (r *configured) Stop() error {
+	r.accounts.Stop()
+	var err error
+	for name, p := range r.polled {
+		if ferr := p.client.FlushTokenStore(); ferr != nil && err == nil {
+			err = fmt.Errorf("failed flushing token store of account %q: %v", name, ferr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,336 @@
+// Package tokenstore provides egobee.TokenStorers for promobee, which unlike
+// those in egobee are safe against crashes and sharing between processes. Stores
+// may be plain or encrypted files, or keys of an HTTP key-value store, and are
+// opened by URI with Open.
+package tokenstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// filePermissions of the store, its backup and lock files. The store holds the
+// only refresh token, so it is readable by its owner alone.
+const filePermissions = 0600
+
+// data is stored in the same format as egobee.NewPersistentTokenStore, so that
+// existing stores continue to work.
+type data struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ValidUntil   time.Time `json:"validUntil"`
+}
+
+// dataFromResponse returns the data to store for r. Like egobee, the expiry is
+// brought forward a little to allow for network and processing delays.
+func dataFromResponse(r *egobee.TokenRefreshResponse) *data {
+	return &data{
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		ValidUntil:   time.Now().Add(r.ExpiresIn.Duration - 15*time.Second),
+	}
+}
+
+// File is a TokenStorer backed by a file on disk. Updates are written to a
+// temporary file which replaces the store only once it is synced, and the
+// previous contents are kept in a backup file alongside the store. File also
+// implements ecobee.Locker, with an advisory lock on a lock file alongside the
+// store, so that only one process at a time may refresh the token.
+type File struct {
+	path string
+	// aead encrypts the store, if not nil.
+	aead cipher.AEAD
+
+	// lockMu is held along with the file lock, by Update or between Lock and
+	// Unlock.
+	lockMu sync.Mutex
+	lock   *os.File // lock file, taken by Lock; protected by lockMu
+
+	mu   sync.RWMutex // protects following members
+	data data
+}
+
+// backupPath of the store at path.
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// OpenFile store at path, which must exist.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewFile store at path containing r, replacing any existing store.
+func NewFile(path string, r *egobee.TokenRefreshResponse) (*File, error) {
+	f := &File{path: path}
+	if err := f.Update(r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newAEAD for an AES key of 16, 24 or 32 bytes.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// OpenEncryptedFile store at path, which must exist, and be encrypted with key
+// using AES-GCM.
+func OpenEncryptedFile(path string, key []byte) (*File, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{path: path, aead: aead}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewEncryptedFile store at path containing r, encrypted with key using AES-GCM,
+// and replacing any existing store.
+func NewEncryptedFile(path string, key []byte, r *egobee.TokenRefreshResponse) (*File, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{path: path, aead: aead}
+	if err := f.Update(r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// seal the encoded data for writing with aead, if not nil. Encrypted files are
+// the nonce, followed by the ciphertext.
+func seal(aead cipher.AEAD, b []byte) ([]byte, error) {
+	if aead == nil {
+		return b, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// open data read from a file, reversing seal.
+func open(aead cipher.AEAD, b []byte) ([]byte, error) {
+	if aead == nil {
+		return b, nil
+	}
+	n := aead.NonceSize()
+	if len(b) < n {
+		return nil, fmt.Errorf("too short to be encrypted")
+	}
+	return aead.Open(nil, b[:n], b[n:], nil)
+}
+
+// errEmpty is returned by readData for an empty file, which is what remains of a
+// store truncated before it was rewritten in place, as egobee does.
+var errEmpty = errors.New("empty")
+
+func (f *File) readData(path string) (*data, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errEmpty
+	}
+	if b, err = open(f.aead, b); err != nil {
+		return nil, fmt.Errorf("failed decrypting token store %q: %v", path, err)
+	}
+	d := &data{}
+	if err := json.Unmarshal(b, d); err != nil {
+		return nil, fmt.Errorf("invalid token store %q: %v", path, err)
+	}
+	return d, nil
+}
+
+// load the store from disk. Only if the store is missing or empty is the backup
+// used instead: its refresh token was revoked by the last successful refresh, so
+// it is only of use if the store was lost before that refresh was written.
+// Invalid contents are an error, rather than a confusing invalid_grant later.
+func (f *File) load() error {
+	d, err := f.readData(f.path)
+	if err != nil {
+		if !os.IsNotExist(err) && err != errEmpty {
+			return err
+		}
+		bak, bakErr := f.readData(backupPath(f.path))
+		if bakErr != nil {
+			if err == errEmpty {
+				return fmt.Errorf("token store %q is empty, and has no usable backup: %v", f.path, bakErr)
+			}
+			return err
+		}
+		log.Printf("WARNING: token store %q is missing or empty, using the previous token from %q. If the token has been refreshed since, it is no longer valid, and the application must be registered again.", f.path, backupPath(f.path))
+		d = bak
+	}
+	f.mu.Lock()
+	f.data = *d
+	f.mu.Unlock()
+	return nil
+}
+
+// AccessToken implements egobee.TokenStorer.
+func (f *File) AccessToken() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.data.AccessToken
+}
+
+// RefreshToken implements egobee.TokenStorer.
+func (f *File) RefreshToken() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.data.RefreshToken
+}
+
+// ValidFor implements egobee.TokenStorer.
+func (f *File) ValidFor() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return time.Until(f.data.ValidUntil)
+}
+
+// Update implements egobee.TokenStorer. The lock is taken for the duration of
+// the write, so it waits for any holder of the lock to release it.
+func (f *File) Update(r *egobee.TokenRefreshResponse) error {
+	f.lockMu.Lock()
+	defer f.lockMu.Unlock()
+	l, err := lockFile(lockPath(f.path))
+	if err != nil {
+		return err
+	}
+	defer unlockFile(l)
+	return f.write(r)
+}
+
+// UpdateLocked implements ecobee.Locker. It must only be called by the caller
+// of Lock, before Unlock.
+func (f *File) UpdateLocked(r *egobee.TokenRefreshResponse) error {
+	return f.write(r)
+}
+
+// write r to the store, keeping its previous contents in the backup. The lock
+// must be held.
+func (f *File) write(r *egobee.TokenRefreshResponse) error {
+	d := dataFromResponse(r)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if b, err = seal(f.aead, b); err != nil {
+		return err
+	}
+	if prev, err := ioutil.ReadFile(f.path); err == nil {
+		if err := writeAtomic(backupPath(f.path), prev); err != nil {
+			return fmt.Errorf("failed backing up token store: %v", err)
+		}
+	}
+	if err := writeAtomic(f.path, b); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.data = *d
+	f.mu.Unlock()
+	return nil
+}
+
+// Lock the store against other processes, blocking until they have released
+// it, and reload it from disk in case another process has updated it.
+func (f *File) Lock() error {
+	f.lockMu.Lock()
+	l, err := lockFile(lockPath(f.path))
+	if err != nil {
+		f.lockMu.Unlock()
+		return err
+	}
+	f.lock = l
+	if err := f.load(); err != nil {
+		f.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unlock the store.
+func (f *File) Unlock() error {
+	l := f.lock
+	f.lock = nil
+	defer f.lockMu.Unlock()
+	return unlockFile(l)
+}
+
+// Flush implements ecobee.Flusher, waiting for any Update, or token refresh
+// while locked, by another goroutine.
+func (f *File) Flush() error {
+	f.lockMu.Lock()
+	f.lockMu.Unlock()
+	return nil
+}
+
+// writeAtomic replaces the file at path with b, such that the file contains
+// either its previous contents or b, even if the process crashes.
+func writeAtomic(path string, b []byte) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed.
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(filePermissions); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Sync the directory, so that the rename itself is durable.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package tokenstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+var _ ecobee.Locker = &File{}
+
+func tempStorePath(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tokenstore")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	return filepath.Join(dir, "store"), func() { os.RemoveAll(dir) }
+}
+
+func response(access, refresh string) *egobee.TokenRefreshResponse {
+	return &egobee.TokenRefreshResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    egobee.TokenDuration{Duration: time.Hour},
+	}
+}
+
+func TestFile(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	f, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	if _, err := os.Stat(backupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("new store: want no backup, got %v", err)
+	}
+	if err := f.Update(response("a2", "r2")); err != nil {
+		t.Fatalf("Update(...): unexpected error: %v", err)
+	}
+	if got := f.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken(): got %q, want %q", got, "r2")
+	}
+	if got := f.ValidFor(); got < 50*time.Minute || got > time.Hour {
+		t.Errorf("ValidFor(): got %v, want just under 1h", got)
+	}
+	bak, err := f.readData(backupPath(path))
+	if err != nil {
+		t.Fatalf("failed reading backup: %v", err)
+	}
+	if bak.RefreshToken != "r1" {
+		t.Errorf("backup: got refresh token %q, want %q", bak.RefreshToken, "r1")
+	}
+
+	// Stores remain readable by egobee, and vice versa.
+	ets, err := egobee.NewPersistentTokenFromDisk(path)
+	if err != nil {
+		t.Fatalf("egobee.NewPersistentTokenFromDisk(...): unexpected error: %v", err)
+	}
+	if got := ets.AccessToken(); got != "a2" {
+		t.Errorf("egobee AccessToken(): got %q, want %q", got, "a2")
+	}
+	if _, err := egobee.NewPersistentTokenStore(response("a3", "r3"), path); err != nil {
+		t.Fatalf("egobee.NewPersistentTokenStore(...): unexpected error: %v", err)
+	}
+	opened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile(...): unexpected error: %v", err)
+	}
+	if got := opened.AccessToken(); got != "a3" {
+		t.Errorf("AccessToken(): got %q, want %q", got, "a3")
+	}
+
+	// No temporary files are left behind.
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed reading dir: %v", err)
+	}
+	for _, e := range entries {
+		switch e.Name() {
+		case "store", "store.bak", "store.lock":
+		default:
+			t.Errorf("unexpected file %q", e.Name())
+		}
+	}
+}
+
+func TestFile_permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	f, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	// A store written by an earlier release is made private by the next update.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("failed changing mode: %v", err)
+	}
+	if err := f.Update(response("a2", "r2")); err != nil {
+		t.Fatalf("Update(...): unexpected error: %v", err)
+	}
+	for _, p := range []string{path, backupPath(path)} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("failed stating %v: %v", p, err)
+		}
+		if got := fi.Mode().Perm(); got != 0600 {
+			t.Errorf("%v: got mode %v, want %v", filepath.Base(p), got, os.FileMode(0600))
+		}
+	}
+}
+
+func TestOpenFile_backup(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	f, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	if err := f.Update(response("a2", "r2")); err != nil {
+		t.Fatalf("Update(...): unexpected error: %v", err)
+	}
+
+	// The refresh token in the backup has been revoked, so an invalid store is
+	// an error rather than a fall back to it.
+	if err := ioutil.WriteFile(path, []byte("{garbage"), filePermissions); err != nil {
+		t.Fatalf("failed corrupting store: %v", err)
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Errorf("OpenFile(...) for corrupt store: want error, got nil")
+	}
+
+	// A store which is empty or missing was lost, so the backup is used.
+	if err := ioutil.WriteFile(path, nil, filePermissions); err != nil {
+		t.Fatalf("failed truncating store: %v", err)
+	}
+	opened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile(...) for empty store: unexpected error: %v", err)
+	}
+	if got := opened.RefreshToken(); got != "r1" {
+		t.Errorf("RefreshToken(): got %q, want %q from the backup", got, "r1")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed removing store: %v", err)
+	}
+	if opened, err = OpenFile(path); err != nil {
+		t.Fatalf("OpenFile(...) for missing store: unexpected error: %v", err)
+	}
+	if got := opened.RefreshToken(); got != "r1" {
+		t.Errorf("RefreshToken(): got %q, want %q from the backup", got, "r1")
+	}
+
+	if _, err := OpenFile(filepath.Join(filepath.Dir(path), "missing")); err == nil {
+		t.Errorf("OpenFile(...) for missing store without backup: want error, got nil")
+	}
+}
+
+func TestFile_Lock(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	// Two stores for the same path stand in for two processes.
+	first, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	second, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile(...): unexpected error: %v", err)
+	}
+
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Lock(): unexpected error: %v", err)
+	}
+	locked := make(chan struct{})
+	go func() {
+		if err := second.Lock(); err != nil {
+			t.Errorf("Lock(): unexpected error: %v", err)
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("second Lock() succeeded while the first was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := first.UpdateLocked(response("a2", "r2")); err != nil {
+		t.Fatalf("UpdateLocked(...): unexpected error: %v", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock(): unexpected error: %v", err)
+	}
+	<-locked
+	// Locking reloads the store, so the second sees the first's refresh.
+	if got := second.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken() after Lock(): got %q, want %q", got, "r2")
+	}
+	if err := second.Unlock(); err != nil {
+		t.Fatalf("Unlock(): unexpected error: %v", err)
+	}
+}
+
+func TestFile_UpdateWaitsForLock(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	f, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	if err := f.Lock(); err != nil {
+		t.Fatalf("Lock(): unexpected error: %v", err)
+	}
+	// Another goroutine updating the store waits for the holder of the lock.
+	updated := make(chan struct{})
+	go func() {
+		if err := f.Update(response("a3", "r3")); err != nil {
+			t.Errorf("Update(...): unexpected error: %v", err)
+		}
+		close(updated)
+	}()
+	select {
+	case <-updated:
+		t.Fatalf("Update(...) succeeded while the lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := f.UpdateLocked(response("a2", "r2")); err != nil {
+		t.Fatalf("UpdateLocked(...): unexpected error: %v", err)
+	}
+	if err := f.Unlock(); err != nil {
+		t.Fatalf("Unlock(): unexpected error: %v", err)
+	}
+	<-updated
+	if got := f.RefreshToken(); got != "r3" {
+		t.Errorf("RefreshToken(): got %q, want %q", got, "r3")
+	}
+	bak, err := f.readData(backupPath(path))
+	if err != nil {
+		t.Fatalf("failed reading backup: %v", err)
+	}
+	if bak.RefreshToken != "r2" {
+		t.Errorf("backup: got refresh token %q, want %q", bak.RefreshToken, "r2")
+	}
+}
+
+func TestFile_Flush(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	f, err := NewFile(path, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewFile(...): unexpected error: %v", err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatalf("Flush(): unexpected error: %v", err)
+	}
+	if err := f.Lock(); err != nil {
+		t.Fatalf("Lock(): unexpected error: %v", err)
+	}
+	// Flush waits for the token refreshed while locked to be written.
+	flushed := make(chan struct{})
+	go func() {
+		if err := f.Flush(); err != nil {
+			t.Errorf("Flush(): unexpected error: %v", err)
+		}
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatalf("Flush() returned while the lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := f.UpdateLocked(response("a2", "r2")); err != nil {
+		t.Fatalf("UpdateLocked(...): unexpected error: %v", err)
+	}
+	if err := f.Unlock(); err != nil {
+		t.Fatalf("Unlock(): unexpected error: %v", err)
+	}
+	<-flushed
+	opened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile(...): unexpected error: %v", err)
+	}
+	if got := opened.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken() after Flush(): got %q, want %q", got, "r2")
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	f, err := NewEncryptedFile(path, key, response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewEncryptedFile(...): unexpected error: %v", err)
+	}
+	// The refresh token is long enough not to occur in the ciphertext by chance.
+	refresh := "refresh-token-2"
+	if err := f.Update(response("a2", refresh)); err != nil {
+		t.Fatalf("Update(...): unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed reading store: %v", err)
+	}
+	if strings.Contains(string(b), refresh) {
+		t.Errorf("store contains the refresh token in the clear: %q", b)
+	}
+
+	opened, err := OpenEncryptedFile(path, key)
+	if err != nil {
+		t.Fatalf("OpenEncryptedFile(...): unexpected error: %v", err)
+	}
+	if got := opened.RefreshToken(); got != refresh {
+		t.Errorf("RefreshToken(): got %q, want %q", got, refresh)
+	}
+	if _, err := OpenEncryptedFile(path, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+		t.Errorf("OpenEncryptedFile(...) with the wrong key: want error, got nil")
+	}
+	if _, err := OpenEncryptedFile(path, []byte("short")); err == nil {
+		t.Errorf("OpenEncryptedFile(...) with an invalid key: want error, got nil")
+	}
+}
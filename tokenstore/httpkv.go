@@ -0,0 +1,223 @@
+package tokenstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// ErrConflict is returned by HTTPKV.Update if another client stored a new
+// refresh token since the store was last read. The store is reloaded, so the
+// other client's token is used from then on.
+var ErrConflict = errors.New("token store was modified concurrently")
+
+// HTTPKV is a TokenStorer backed by a single key of an HTTP key-value store. The
+// value is read with GET, and written with PUT. Writes are conditional on the
+// ETag of the last value read, with If-Match, or If-None-Match: * if there was
+// no value, and the store must respond 412 Precondition Failed if the condition
+// does not hold.
+//
+// HTTPKV also implements ecobee.Locker, but only optimistically between
+// processes: Lock reloads the store, and Update fails with ErrConflict if
+// another client has refreshed the token since. Writes by other clients which
+// leave the refresh token unchanged are retried. Within the process, Lock excludes Update by
+// other goroutines until Unlock.
+type HTTPKV struct {
+	url    string
+	client *http.Client
+
+	// lockMu is held by Update, or between Lock and Unlock.
+	lockMu sync.Mutex
+
+	mu   sync.RWMutex // protects following members
+	data data
+	etag string // of data, or empty if the key has no value
+}
+
+// OpenHTTPKV store at url. The key need not yet have a value.
+func OpenHTTPKV(url string) (*HTTPKV, error) {
+	s := &HTTPKV{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+	if err := s.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewHTTPKV store at url containing r, replacing any existing value.
+func NewHTTPKV(url string, r *egobee.TokenRefreshResponse) (*HTTPKV, error) {
+	s, err := OpenHTTPKV(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Update(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *HTTPKV) load(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed reading token store: %v", err)
+	}
+	defer res.Body.Close()
+
+	d := data{}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+	case (res.StatusCode / 100) == 2:
+		if err := json.NewDecoder(res.Body).Decode(&d); err != nil {
+			return fmt.Errorf("invalid token store %q: %v", s.url, err)
+		}
+	default:
+		return fmt.Errorf("failed reading token store: %v", res.Status)
+	}
+	s.mu.Lock()
+	s.data = d
+	s.etag = res.Header.Get("ETag")
+	s.mu.Unlock()
+	return nil
+}
+
+// AccessToken implements egobee.TokenStorer.
+func (s *HTTPKV) AccessToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.AccessToken
+}
+
+// RefreshToken implements egobee.TokenStorer.
+func (s *HTTPKV) RefreshToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RefreshToken
+}
+
+// ValidFor implements egobee.TokenStorer.
+func (s *HTTPKV) ValidFor() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Until(s.data.ValidUntil)
+}
+
+// Update implements egobee.TokenStorer. It waits for any holder of the lock to
+// release it.
+func (s *HTTPKV) Update(r *egobee.TokenRefreshResponse) error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	return s.write(r)
+}
+
+// UpdateLocked implements ecobee.Locker. It must only be called by the caller
+// of Lock, before Unlock.
+func (s *HTTPKV) UpdateLocked(r *egobee.TokenRefreshResponse) error {
+	return s.write(r)
+}
+
+// maxWriteAttempts bounds the conditional writes of a single update, which are
+// retried while other clients write the store without rotating the token.
+const maxWriteAttempts = 3
+
+// write r to the store, conditional on the last value read. If another client
+// has written the store since, but the refresh token is still the one which was
+// read, the write is retried: that token may already have been spent to obtain
+// r, which must not be lost. Only if the other client has stored a new refresh
+// token is its token used instead, and ErrConflict returned.
+func (s *HTTPKV) write(r *egobee.TokenRefreshResponse) error {
+	d := dataFromResponse(r)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	spent := s.RefreshToken()
+	for attempt := 1; ; attempt++ {
+		etag, ok, err := s.put(b)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.mu.Lock()
+			s.data = *d
+			s.etag = etag
+			s.mu.Unlock()
+			break
+		}
+		if err := s.load(context.Background()); err != nil {
+			return fmt.Errorf("%v, and reloading failed: %v", ErrConflict, err)
+		}
+		if s.RefreshToken() != spent || attempt == maxWriteAttempts {
+			return ErrConflict
+		}
+	}
+	if s.etag == "" {
+		// Without an ETag, the next write could not be made conditional.
+		return s.load(context.Background())
+	}
+	return nil
+}
+
+// put b to the store, conditional on the last value read. It reports the ETag
+// of the new value, and false if the condition did not hold.
+func (s *HTTPKV) put(b []byte) (string, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, s.url, bytes.NewReader(b))
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.mu.RLock()
+	if s.etag != "" {
+		req.Header.Set("If-Match", s.etag)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+	s.mu.RUnlock()
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed writing token store: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return "", false, nil
+	}
+	if (res.StatusCode / 100) != 2 {
+		return "", false, fmt.Errorf("failed writing token store: %v", res.Status)
+	}
+	return res.Header.Get("ETag"), true, nil
+}
+
+// Lock the store against other goroutines, and reload it in case another
+// client has updated it.
+func (s *HTTPKV) Lock() error {
+	s.lockMu.Lock()
+	if err := s.load(context.Background()); err != nil {
+		s.lockMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unlock the store.
+func (s *HTTPKV) Unlock() error {
+	s.lockMu.Unlock()
+	return nil
+}
+
+// Flush implements ecobee.Flusher, waiting for any Update, or token refresh
+// while locked, by another goroutine.
+func (s *HTTPKV) Flush() error {
+	s.lockMu.Lock()
+	s.lockMu.Unlock()
+	return nil
+}
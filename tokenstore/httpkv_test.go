@@ -0,0 +1,178 @@
+package tokenstore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/egobee"
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+var _ ecobee.Locker = &HTTPKV{}
+
+// kvServer is a stub of an HTTP key-value store, holding a single value.
+type kvServer struct {
+	mu      sync.Mutex
+	value   []byte
+	version int
+}
+
+func (s *kvServer) etag() string {
+	return fmt.Sprintf(`"%d"`, s.version)
+}
+
+func (s *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if s.value == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", s.etag())
+		w.Write(s.value)
+	case http.MethodPut:
+		if m := r.Header.Get("If-Match"); m != "" && (s.value == nil || m != s.etag()) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && s.value != nil {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.value = b
+		s.version++
+		w.Header().Set("ETag", s.etag())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPKV(t *testing.T) {
+	srv := httptest.NewServer(&kvServer{})
+	defer srv.Close()
+
+	if _, err := OpenHTTPKV(srv.URL + "/promobee"); err != nil {
+		t.Fatalf("OpenHTTPKV(...) with no value: unexpected error: %v", err)
+	}
+	first, err := NewHTTPKV(srv.URL+"/promobee", response("a1", "r1"))
+	if err != nil {
+		t.Fatalf("NewHTTPKV(...): unexpected error: %v", err)
+	}
+	second, err := OpenHTTPKV(srv.URL + "/promobee")
+	if err != nil {
+		t.Fatalf("OpenHTTPKV(...): unexpected error: %v", err)
+	}
+	if got := second.RefreshToken(); got != "r1" {
+		t.Errorf("RefreshToken(): got %q, want %q", got, "r1")
+	}
+
+	if err := first.Update(response("a2", "r2")); err != nil {
+		t.Fatalf("Update(...): unexpected error: %v", err)
+	}
+	// The second store has not seen the first's update, so its write conflicts,
+	// and it picks up the first's token instead.
+	if err := second.Update(response("a3", "r3")); err != ErrConflict {
+		t.Errorf("Update(...) of stale store: got %v, want %v", err, ErrConflict)
+	}
+	if got := second.RefreshToken(); got != "r2" {
+		t.Errorf("RefreshToken() after conflict: got %q, want %q", got, "r2")
+	}
+	if err := second.Update(response("a3", "r3")); err != nil {
+		t.Errorf("Update(...) after reload: unexpected error: %v", err)
+	}
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Lock(): unexpected error: %v", err)
+	}
+	if got := first.RefreshToken(); got != "r3" {
+		t.Errorf("RefreshToken() after Lock(): got %q, want %q", got, "r3")
+	}
+	// Flush waits for the token refreshed while locked to be written.
+	flushed := make(chan struct{})
+	go func() {
+		first.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatalf("Flush() returned while the lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := first.UpdateLocked(response("a4", "r4")); err != nil {
+		t.Fatalf("UpdateLocked(...): unexpected error: %v", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock(): unexpected error: %v", err)
+	}
+	<-flushed
+}
+
+func TestHTTPKV_refreshConflict(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// other is the token stored by another client while this one refreshes.
+		other   *egobee.TokenRefreshResponse
+		wantErr bool
+		want    string
+	}{
+		// The other client rewrote the store without refreshing, so the refresh
+		// token this one spent is still stored, and its new token must be kept.
+		{name: "unchanged", other: &egobee.TokenRefreshResponse{AccessToken: "a1", RefreshToken: "r1"}, want: "r2"},
+		// The other client refreshed the token too, so its token is used.
+		{name: "rotated", other: response("a9", "r9"), wantErr: true, want: "r9"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := httptest.NewServer(&kvServer{})
+			defer kv.Close()
+			expired := &egobee.TokenRefreshResponse{AccessToken: "a1", RefreshToken: "r1"}
+			s, err := NewHTTPKV(kv.URL+"/promobee", expired)
+			if err != nil {
+				t.Fatalf("NewHTTPKV(...): unexpected error: %v", err)
+			}
+			other, err := OpenHTTPKV(kv.URL + "/promobee")
+			if err != nil {
+				t.Fatalf("OpenHTTPKV(...): unexpected error: %v", err)
+			}
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/token" {
+					if err := other.Update(tt.other); err != nil {
+						t.Errorf("Update(...) by other client: unexpected error: %v", err)
+					}
+					fmt.Fprint(w, `{"access_token": "a2", "refresh_token": "r2", "expires_in": 3600}`)
+					return
+				}
+				fmt.Fprint(w, `{"status": {"code": 0}}`)
+			}))
+			defer api.Close()
+
+			c := ecobee.New("app", s, &egobee.Options{APIHost: api.URL})
+			err = c.CallFunctions(context.Background(), ecobee.SelectThermostat("123"), ecobee.ResumeProgram(false))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CallFunctions(...): got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := s.RefreshToken(); got != tt.want {
+				t.Errorf("RefreshToken(): got %q, want %q", got, tt.want)
+			}
+			stored, err := OpenHTTPKV(kv.URL + "/promobee")
+			if err != nil {
+				t.Fatalf("OpenHTTPKV(...): unexpected error: %v", err)
+			}
+			if got := stored.RefreshToken(); got != tt.want {
+				t.Errorf("stored RefreshToken(): got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package tokenstore
+
+import "os"
+
+// lockFile only opens the file at path, since advisory locks are not supported
+// on this platform. Stores must not be shared between processes.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, filePermissions)
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,69 @@
+package tokenstore
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Pending is the file alongside a file store in which an authorization which
+// has not yet been granted is kept, so that registering may be resumed. The
+// authorization may be exchanged for tokens until it expires, so the file is
+// only readable by its owner, and is encrypted like an encfile:// store.
+// Pending implements ecobee.PinFile.
+type Pending struct {
+	path string
+	// aead encrypts the file, if not nil.
+	aead cipher.AEAD
+}
+
+// OpenPending file for the store at uri, which need not exist. It is nil if the
+// store is not a file.
+func OpenPending(uri string) (*Pending, error) {
+	s, err := parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pending{path: s.path + ".pending"}
+	switch s.scheme {
+	case "file":
+	case "encfile":
+		if p.aead, err = newAEAD(s.key); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	return p, nil
+}
+
+// ReadFile returns the contents of the file, or an error for which os.IsNotExist
+// holds if there is none.
+func (p *Pending) ReadFile() ([]byte, error) {
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = open(p.aead, b); err != nil {
+		return nil, fmt.Errorf("failed decrypting %q: %v", p.path, err)
+	}
+	return b, nil
+}
+
+// WriteFile replaces the contents of the file with b.
+func (p *Pending) WriteFile(b []byte) error {
+	b, err := seal(p.aead, b)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(p.path, b)
+}
+
+// Remove the file, once the authorization has been granted or has expired.
+func (p *Pending) Remove() error {
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
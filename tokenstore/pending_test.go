@@ -0,0 +1,116 @@
+package tokenstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/cfunkhouser/promobee/ecobee"
+)
+
+var _ ecobee.PinFile = &Pending{}
+
+func TestOpenPending(t *testing.T) {
+	os.Setenv("TEST_STORE_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	defer os.Unsetenv("TEST_STORE_KEY")
+
+	for _, tt := range []struct {
+		uri, want string
+		encrypted bool
+	}{
+		{uri: "/path/to/store", want: "/path/to/store.pending"},
+		{uri: "encfile:///path/to/store?key_env=TEST_STORE_KEY", want: "/path/to/store.pending", encrypted: true},
+		{uri: "http-kv://kv.local:8500/v1/kv/promobee"},
+	} {
+		p, err := OpenPending(tt.uri)
+		if err != nil {
+			t.Errorf("OpenPending(%q): unexpected error: %v", tt.uri, err)
+			continue
+		}
+		if tt.want == "" {
+			if p != nil {
+				t.Errorf("OpenPending(%q): got %+v, want nil", tt.uri, p)
+			}
+			continue
+		}
+		if p == nil || p.path != tt.want || (p.aead != nil) != tt.encrypted {
+			t.Errorf("OpenPending(%q): got %+v, want %v, encrypted %v", tt.uri, p, tt.want, tt.encrypted)
+		}
+	}
+	for _, uri := range []string{"encfile:///path/to/store?key_env=TEST_STORE_MISSING", "s3://bucket/store"} {
+		if _, err := OpenPending(uri); err == nil {
+			t.Errorf("OpenPending(%q): want error, got nil", uri)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	os.Setenv("TEST_STORE_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	defer os.Unsetenv("TEST_STORE_KEY")
+	pending := []byte(`{"ecobeePin": "abc9", "code": "secret-authorization-code"}`)
+
+	for _, tt := range []struct {
+		uri       string
+		encrypted bool
+	}{
+		{uri: path},
+		{uri: "encfile://" + path + "?key_env=TEST_STORE_KEY", encrypted: true},
+	} {
+		p, err := OpenPending(tt.uri)
+		if err != nil {
+			t.Fatalf("OpenPending(%q): unexpected error: %v", tt.uri, err)
+		}
+		if _, err := p.ReadFile(); !os.IsNotExist(err) {
+			t.Errorf("%v: ReadFile() before WriteFile(): got %v, want not exist", tt.uri, err)
+		}
+		if err := p.WriteFile(pending); err != nil {
+			t.Fatalf("%v: WriteFile(...): unexpected error: %v", tt.uri, err)
+		}
+		b, err := ioutil.ReadFile(path + ".pending")
+		if err != nil {
+			t.Fatalf("%v: failed reading pending file: %v", tt.uri, err)
+		}
+		// The authorization code is long enough not to occur in the ciphertext by
+		// chance.
+		if got := bytes.Contains(b, []byte("secret-authorization-code")); got == tt.encrypted {
+			t.Errorf("%v: pending file contains the authorization code in the clear: got %v, want %v", tt.uri, got, !tt.encrypted)
+		}
+		if fi, err := os.Stat(path + ".pending"); err != nil {
+			t.Fatalf("%v: failed stating pending file: %v", tt.uri, err)
+		} else if got := fi.Mode().Perm(); runtime.GOOS != "windows" && got != 0600 {
+			t.Errorf("%v: got mode %v, want %v", tt.uri, got, os.FileMode(0600))
+		}
+		if got, err := p.ReadFile(); err != nil || !bytes.Equal(got, pending) {
+			t.Errorf("%v: ReadFile(): got %q, %v, want %q", tt.uri, got, err, pending)
+		}
+		if err := p.Remove(); err != nil {
+			t.Errorf("%v: Remove(): unexpected error: %v", tt.uri, err)
+		}
+		if err := p.Remove(); err != nil {
+			t.Errorf("%v: Remove() again: unexpected error: %v", tt.uri, err)
+		}
+	}
+
+	// A pending file written with another key cannot be read.
+	os.Setenv("TEST_OTHER_KEY", base64.StdEncoding.EncodeToString([]byte("fedcba9876543210")))
+	defer os.Unsetenv("TEST_OTHER_KEY")
+	p, err := OpenPending("encfile://" + path + "?key_env=TEST_STORE_KEY")
+	if err != nil {
+		t.Fatalf("OpenPending(...): unexpected error: %v", err)
+	}
+	if err := p.WriteFile(pending); err != nil {
+		t.Fatalf("WriteFile(...): unexpected error: %v", err)
+	}
+	other, err := OpenPending("encfile://" + path + "?key_env=TEST_OTHER_KEY")
+	if err != nil {
+		t.Fatalf("OpenPending(...): unexpected error: %v", err)
+	}
+	if _, err := other.ReadFile(); err == nil || os.IsNotExist(err) {
+		t.Errorf("ReadFile() with another key: got %v, want decryption error", err)
+	}
+}
@@ -0,0 +1,92 @@
+package tokenstore
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cfunkhouser/egobee"
+)
+
+// DefaultKeyEnv is the environment variable from which the key of an encfile://
+// store is read, unless the key_env parameter names another.
+const DefaultKeyEnv = "PROMOBEE_STORE_KEY"
+
+// store is a location parsed from a URI.
+type store struct {
+	scheme string
+	path   string
+	key    []byte
+}
+
+// parse a store URI, which is one of:
+//
+//	/path/to/store or file:///path/to/store
+//	encfile:///path/to/store[?key_env=VAR]
+//	http-kv://host/path/to/key or https-kv://host/path/to/key
+//
+// The key of an encfile:// store is read from the environment as base64, and
+// must decode to 16, 24 or 32 bytes.
+func parse(uri string) (*store, error) {
+	if !strings.Contains(uri, "://") {
+		return &store{scheme: "file", path: uri}, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token store %q: %v", uri, err)
+	}
+	s := &store{scheme: u.Scheme, path: u.Host + u.Path}
+	switch u.Scheme {
+	case "file":
+	case "encfile":
+		env := u.Query().Get("key_env")
+		if env == "" {
+			env = DefaultKeyEnv
+		}
+		encoded := os.Getenv(env)
+		if encoded == "" {
+			return nil, fmt.Errorf("token store %q requires a base64 key in $%v", uri, env)
+		}
+		if s.key, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("invalid key in $%v: %v", env, err)
+		}
+	case "http-kv", "https-kv":
+		u.Scheme = strings.TrimSuffix(u.Scheme, "-kv")
+		s.path = u.String()
+	default:
+		return nil, fmt.Errorf("unsupported token store scheme %q", u.Scheme)
+	}
+	return s, nil
+}
+
+// Open the existing token store at uri.
+func Open(uri string) (egobee.TokenStorer, error) {
+	s, err := parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch s.scheme {
+	case "encfile":
+		return OpenEncryptedFile(s.path, s.key)
+	case "http-kv", "https-kv":
+		return OpenHTTPKV(s.path)
+	}
+	return OpenFile(s.path)
+}
+
+// Create a token store at uri containing r, replacing any existing store.
+func Create(uri string, r *egobee.TokenRefreshResponse) (egobee.TokenStorer, error) {
+	s, err := parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch s.scheme {
+	case "encfile":
+		return NewEncryptedFile(s.path, s.key, r)
+	case "http-kv", "https-kv":
+		return NewHTTPKV(s.path, r)
+	}
+	return NewFile(s.path, r)
+}
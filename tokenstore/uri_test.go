@@ -0,0 +1,47 @@
+package tokenstore
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	os.Setenv("TEST_STORE_KEY", base64.StdEncoding.EncodeToString(key))
+	defer os.Unsetenv("TEST_STORE_KEY")
+
+	for _, tt := range []struct {
+		uri                  string
+		wantScheme, wantPath string
+		wantKey              bool
+		wantErr              bool
+	}{
+		{uri: "/path/to/store", wantScheme: "file", wantPath: "/path/to/store"},
+		{uri: "relative/store", wantScheme: "file", wantPath: "relative/store"},
+		{uri: "file:///path/to/store", wantScheme: "file", wantPath: "/path/to/store"},
+		{uri: "encfile:///path/to/store?key_env=TEST_STORE_KEY", wantScheme: "encfile", wantPath: "/path/to/store", wantKey: true},
+		{uri: "encfile:///path/to/store?key_env=TEST_STORE_MISSING", wantErr: true},
+		{uri: "http-kv://kv.local:8500/v1/kv/promobee", wantScheme: "http-kv", wantPath: "http://kv.local:8500/v1/kv/promobee"},
+		{uri: "https-kv://kv.local/promobee?raw", wantScheme: "https-kv", wantPath: "https://kv.local/promobee?raw"},
+		{uri: "s3://bucket/store", wantErr: true},
+	} {
+		got, err := parse(tt.uri)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parse(%q): want error, got nil", tt.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse(%q): unexpected error: %v", tt.uri, err)
+			continue
+		}
+		if got.scheme != tt.wantScheme || got.path != tt.wantPath {
+			t.Errorf("parse(%q): got %v %q, want %v %q", tt.uri, got.scheme, got.path, tt.wantScheme, tt.wantPath)
+		}
+		if tt.wantKey && string(got.key) != string(key) {
+			t.Errorf("parse(%q): got key %q, want %q", tt.uri, got.key, key)
+		}
+	}
+}